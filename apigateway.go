@@ -0,0 +1,137 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+)
+
+// HandleAPIGatewayRequest handles the Telegram updates received
+// through the REST API Lambda Proxy integration.
+func HandleAPIGatewayRequest(request events.APIGatewayProxyRequest, bot sender.Sender, deps services.Dependencies) events.APIGatewayProxyResponse {
+
+	body, err := decodeBody(request.Body, request.IsBase64Encoded)
+	if err != nil {
+		log.Println("HandleAPIGatewayRequest: unable to decode body:", err)
+		return events.APIGatewayProxyResponse{StatusCode: http.StatusBadRequest, Body: http.StatusText(http.StatusBadRequest)}
+	}
+
+	status, reply := handleWebhook(webhookRequest{
+		method:      request.HTTPMethod,
+		secretToken: headerValue(request.Headers, secretTokenHeader),
+		sourceIP:    request.RequestContext.Identity.SourceIP,
+		body:        body,
+	}, bot, deps)
+
+	return events.APIGatewayProxyResponse{StatusCode: status, Headers: webhookHeaders(reply), Body: string(reply)}
+
+}
+
+// HandleHTTPAPIRequest handles the Telegram updates received
+// through an HTTP API with the payload format version 2.0.
+func HandleHTTPAPIRequest(request events.APIGatewayV2HTTPRequest, bot sender.Sender, deps services.Dependencies) events.APIGatewayV2HTTPResponse {
+
+	body, err := decodeBody(request.Body, request.IsBase64Encoded)
+	if err != nil {
+		log.Println("HandleHTTPAPIRequest: unable to decode body:", err)
+		return events.APIGatewayV2HTTPResponse{StatusCode: http.StatusBadRequest, Body: http.StatusText(http.StatusBadRequest)}
+	}
+
+	status, reply := handleWebhook(webhookRequest{
+		method:      request.RequestContext.HTTP.Method,
+		secretToken: headerValue(request.Headers, secretTokenHeader),
+		sourceIP:    request.RequestContext.HTTP.SourceIP,
+		body:        body,
+	}, bot, deps)
+
+	return events.APIGatewayV2HTTPResponse{StatusCode: status, Headers: webhookHeaders(reply), Body: string(reply)}
+
+}
+
+// webhookRequest is a webhook request, regardless
+// of the way it was received.
+type webhookRequest struct {
+	method      string
+	secretToken string
+	sourceIP    string
+	body        []byte
+}
+
+// handleWebhook handles a webhook request and returns
+// the HTTP status code and body of the response.
+// Requests that can't be authenticated are rejected
+// before the update is decoded.
+// If WebhookReply is enabled, the body may contain the last
+// text message to send, in the form of a sendMessage call.
+func handleWebhook(request webhookRequest, bot sender.Sender, deps services.Dependencies) (status int, reply []byte) {
+
+	if request.method != http.MethodPost {
+		return http.StatusMethodNotAllowed, nil
+	}
+
+	err := authenticateWebhook(request)
+	if err != nil {
+		log.Println("handleWebhook: rejecting request:", err)
+		return http.StatusUnauthorized, nil
+	}
+
+	var update tgbotapi.Update
+	err = json.Unmarshal(request.body, &update)
+	if err != nil {
+		log.Println("handleWebhook: unable to decode update:", err)
+		return http.StatusBadRequest, nil
+	}
+
+	if !repository.WebhookReply {
+		HandleUpdate(update, bot, deps)
+		return http.StatusOK, nil
+	}
+
+	webhookReply := sender.NewWebhookReply(bot)
+	HandleUpdate(update, webhookReply, deps)
+
+	reply, err = webhookReply.Body()
+	if err != nil {
+		log.Println("handleWebhook: unable to build the webhook reply:", err)
+		reply = nil
+	}
+
+	return http.StatusOK, reply
+
+}
+
+// webhookHeaders returns the headers of a webhook
+// response with the provided body.
+func webhookHeaders(reply []byte) map[string]string {
+
+	if len(reply) == 0 {
+		return nil
+	}
+
+	return map[string]string{"Content-Type": "application/json"}
+
+}
+
+// decodeBody returns the body of an API Gateway
+// request, decoding it if it's base64 encoded.
+func decodeBody(body string, isBase64Encoded bool) ([]byte, error) {
+
+	if !isBase64Encoded {
+		return []byte(body), nil
+	}
+
+	return base64.StdEncoding.DecodeString(body)
+
+}
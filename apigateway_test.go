@@ -0,0 +1,139 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender/sendertest"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+)
+
+const startCommandUpdate = `{"update_id":1,"message":{"message_id":2,"from":{"id":3},"chat":{"id":3,"type":"private"},"text":"/start","entities":[{"offset":0,"length":6,"type":"bot_command"}]}}`
+
+func TestHandleAPIGatewayRequest(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		request    events.APIGatewayProxyRequest
+		wantStatus int
+	}{
+		{
+			name:       "GET request",
+			request:    events.APIGatewayProxyRequest{HTTPMethod: http.MethodGet},
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "Malformed update",
+			request:    events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Body: `{"update_id":`},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Malformed base64 body",
+			request:    events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Body: "{}", IsBase64Encoded: true},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Update without message",
+			request:    events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Body: `{"update_id":1}`},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Base64 encoded update",
+			request:    events.APIGatewayProxyRequest{HTTPMethod: http.MethodPost, Body: base64.StdEncoding.EncodeToString([]byte(`{"update_id":1}`)), IsBase64Encoded: true},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HandleAPIGatewayRequest(tt.request, &sendertest.Recorder{}, newTestDependencies())
+			if got.StatusCode != tt.wantStatus {
+				t.Errorf("HandleAPIGatewayRequest() status = %d, want %d", got.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+}
+
+func TestHandleHTTPAPIRequest(t *testing.T) {
+
+	newRequest := func(method string, body string) events.APIGatewayV2HTTPRequest {
+		request := events.APIGatewayV2HTTPRequest{Version: "2.0", Body: body}
+		request.RequestContext.HTTP.Method = method
+		return request
+	}
+
+	tests := []struct {
+		name       string
+		request    events.APIGatewayV2HTTPRequest
+		wantStatus int
+	}{
+		{
+			name:       "GET request",
+			request:    newRequest(http.MethodGet, ""),
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "Malformed update",
+			request:    newRequest(http.MethodPost, "not json"),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Update without message",
+			request:    newRequest(http.MethodPost, `{"update_id":1}`),
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := HandleHTTPAPIRequest(tt.request, &sendertest.Recorder{}, newTestDependencies())
+			if got.StatusCode != tt.wantStatus {
+				t.Errorf("HandleHTTPAPIRequest() status = %d, want %d", got.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+
+}
+
+func Test_handleWebhook_WebhookReply(t *testing.T) {
+
+	repository.WebhookReply = true
+	defer func() { repository.WebhookReply = false }()
+
+	bot := &sendertest.Recorder{}
+	status, reply := handleWebhook(webhookRequest{method: http.MethodPost, body: []byte(startCommandUpdate)}, bot, newTestDependencies())
+	if status != http.StatusOK {
+		t.Errorf("handleWebhook() status = %d, want %d", status, http.StatusOK)
+	}
+
+	// Only the chat action must have been sent,
+	// the reply must be in the response body.
+	if len(bot.Sent) != 1 {
+		t.Errorf("handleWebhook() sent %d requests, want 1: %v", len(bot.Sent), bot.Sent)
+	}
+
+	want := `{"chat_id":3,"method":"sendMessage","parse_mode":"HTML","text":"Welcome!\nSend me an Amazon link and I'll send you the referral version, if the region is supported."}`
+	if string(reply) != want {
+		t.Errorf("handleWebhook() reply = %s, want %s", reply, want)
+	}
+
+}
+
+func newTestDependencies() services.Dependencies {
+	return services.Dependencies{
+		Store:     persistence.NewMemoryStore(),
+		Resolver:  urlwork.NewHTTPResolver(nil),
+		Shortener: urlwork.NoopShortener{},
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/networks"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+)
+
+// secretTokenHeader is the header in which Telegram sends the
+// secret token set with the secret_token field of setWebhook.
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// telegramNetworks are the networks Telegram sends the webhook requests from.
+// See https://core.telegram.org/bots/webhooks#the-short-version
+var telegramNetworks = networks.MustParse(
+	"149.154.160.0/20",
+	"91.108.4.0/22",
+)
+
+// authenticateWebhook makes sure that the webhook request comes
+// from Telegram, checking the secret token and, if enabled,
+// the source IP address.
+func authenticateWebhook(request webhookRequest) error {
+
+	if repository.WebhookSecretToken != "" &&
+		subtle.ConstantTimeCompare([]byte(request.secretToken), []byte(repository.WebhookSecretToken)) != 1 {
+		return errors.Errorf("authenticateWebhook: wrong or missing secret token from %s", request.sourceIP)
+	}
+
+	if repository.WebhookCheckSourceIP && !isTelegramIP(request.sourceIP) {
+		return errors.Errorf("authenticateWebhook: %s is not a Telegram address", request.sourceIP)
+	}
+
+	return nil
+
+}
+
+// isTelegramIP returns true if the address
+// belongs to one of Telegram's networks.
+func isTelegramIP(address string) bool {
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	return networks.Contain(telegramNetworks, ip)
+
+}
+
+// headerValue returns the value of a header from a map of headers,
+// ignoring the case of the name as API Gateway doesn't normalize it.
+func headerValue(headers map[string]string, name string) string {
+
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+
+	return ""
+
+}
+
+// clientIP returns the IP address of the client that sent the request.
+// When the request comes from one of the trusted proxies, the address
+// is the last one in X-Forwarded-For that isn't a trusted proxy, or the
+// X-Real-IP one if there's no X-Forwarded-For header.
+func clientIP(r *http.Request) string {
+
+	address := remoteIP(r.RemoteAddr)
+	if !isTrustedProxy(address) {
+		return address
+	}
+
+	forwarded := r.Header["X-Forwarded-For"]
+	if len(forwarded) == 0 {
+		if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+			return realIP
+		}
+		return address
+	}
+
+	// Each proxy appends the address it received the request from,
+	// so the addresses before the last untrusted one can be forged.
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		if hop := strings.TrimSpace(hops[i]); hop != "" {
+			address = hop
+			if !isTrustedProxy(address) {
+				break
+			}
+		}
+	}
+
+	return address
+
+}
+
+// isTrustedProxy returns true if the address
+// belongs to one of the trusted proxies.
+func isTrustedProxy(address string) bool {
+
+	ip := net.ParseIP(address)
+	return ip != nil && networks.Contain(repository.WebhookTrustedProxies, ip)
+
+}
+
+// remoteIP returns the IP address from a host:port remote address.
+func remoteIP(remoteAddress string) string {
+
+	host, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		return remoteAddress
+	}
+
+	return host
+
+}
@@ -0,0 +1,265 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/networks"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender/sendertest"
+)
+
+// forgedBroadcastUpdate is a /broadcast command from a spoofed admin ID.
+const forgedBroadcastUpdate = `{"update_id":1,"message":{"message_id":2,"from":{"id":1},"chat":{"id":1,"type":"private"},"text":"/broadcast spam","entities":[{"offset":0,"length":10,"type":"bot_command"}]}}`
+
+func setWebhookVerification(secretToken string, checkSourceIP bool) func() {
+
+	repository.WebhookSecretToken = secretToken
+	repository.WebhookCheckSourceIP = checkSourceIP
+
+	return func() {
+		repository.WebhookSecretToken = ""
+		repository.WebhookCheckSourceIP = false
+	}
+
+}
+
+func Test_authenticateWebhook(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		secretToken   string
+		checkSourceIP bool
+		request       webhookRequest
+		wantErr       bool
+	}{
+		{
+			name:    "No verification",
+			request: webhookRequest{},
+			wantErr: false,
+		},
+		{
+			name:        "Right secret token",
+			secretToken: "s3cr3t",
+			request:     webhookRequest{secretToken: "s3cr3t"},
+			wantErr:     false,
+		},
+		{
+			name:        "Wrong secret token",
+			secretToken: "s3cr3t",
+			request:     webhookRequest{secretToken: "guess"},
+			wantErr:     true,
+		},
+		{
+			name:        "Missing secret token",
+			secretToken: "s3cr3t",
+			request:     webhookRequest{},
+			wantErr:     true,
+		},
+		{
+			name:          "Telegram source IP",
+			checkSourceIP: true,
+			request:       webhookRequest{sourceIP: "149.154.167.220"},
+			wantErr:       false,
+		},
+		{
+			name:          "Foreign source IP",
+			checkSourceIP: true,
+			request:       webhookRequest{sourceIP: "203.0.113.7"},
+			wantErr:       true,
+		},
+		{
+			name:          "Invalid source IP",
+			checkSourceIP: true,
+			request:       webhookRequest{sourceIP: "localhost"},
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			defer setWebhookVerification(tt.secretToken, tt.checkSourceIP)()
+
+			err := authenticateWebhook(tt.request)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authenticateWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+		})
+	}
+
+}
+
+func TestForgedUpdatesAreRejected(t *testing.T) {
+
+	defer setWebhookVerification("s3cr3t", false)()
+
+	tests := []struct {
+		name        string
+		secretToken string
+		wantStatus  int
+		wantSent    int
+	}{
+		{
+			name:        "Forged update",
+			secretToken: "",
+			wantStatus:  http.StatusUnauthorized,
+			wantSent:    0,
+		},
+		{
+			name:        "Genuine update",
+			secretToken: "s3cr3t",
+			wantStatus:  http.StatusOK,
+			wantSent:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// API Gateway REST API, which keeps the header case.
+			bot := &sendertest.Recorder{}
+			proxyResponse := HandleAPIGatewayRequest(events.APIGatewayProxyRequest{
+				HTTPMethod: http.MethodPost,
+				Headers:    map[string]string{secretTokenHeader: tt.secretToken},
+				Body:       forgedBroadcastUpdate,
+			}, bot, newTestDependencies())
+
+			if proxyResponse.StatusCode != tt.wantStatus || len(bot.Sent) != tt.wantSent {
+				t.Errorf("HandleAPIGatewayRequest() status = %d with %d requests sent, want %d with %d", proxyResponse.StatusCode, len(bot.Sent), tt.wantStatus, tt.wantSent)
+			}
+
+			// HTTP API, which lowercases the headers.
+			bot = &sendertest.Recorder{}
+			httpAPIRequest := events.APIGatewayV2HTTPRequest{
+				Headers: map[string]string{strings.ToLower(secretTokenHeader): tt.secretToken},
+				Body:    forgedBroadcastUpdate,
+			}
+			httpAPIRequest.RequestContext.HTTP.Method = http.MethodPost
+			httpAPIResponse := HandleHTTPAPIRequest(httpAPIRequest, bot, newTestDependencies())
+
+			if httpAPIResponse.StatusCode != tt.wantStatus || len(bot.Sent) != tt.wantSent {
+				t.Errorf("HandleHTTPAPIRequest() status = %d with %d requests sent, want %d with %d", httpAPIResponse.StatusCode, len(bot.Sent), tt.wantStatus, tt.wantSent)
+			}
+
+			// Standalone webhook server.
+			bot = &sendertest.Recorder{}
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(forgedBroadcastUpdate))
+			request.Header.Set(secretTokenHeader, tt.secretToken)
+			recorder := httptest.NewRecorder()
+			webhookHandler(bot, newTestDependencies()).ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus || len(bot.Sent) != tt.wantSent {
+				t.Errorf("webhookHandler() status = %d with %d requests sent, want %d with %d", recorder.Code, len(bot.Sent), tt.wantStatus, tt.wantSent)
+			}
+
+		})
+	}
+
+}
+
+func Test_clientIP(t *testing.T) {
+
+	repository.WebhookTrustedProxies = networks.MustParse("10.0.0.0/8", "192.0.2.1")
+	defer func() { repository.WebhookTrustedProxies = nil }()
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{"Direct request", "149.154.167.220:443", nil, "", "149.154.167.220"},
+		{"Untrusted proxy", "203.0.113.7:443", []string{"149.154.167.220"}, "149.154.167.220", "203.0.113.7"},
+		{"Trusted proxy", "10.0.0.2:443", []string{"149.154.167.220"}, "", "149.154.167.220"},
+		{"Forged address", "10.0.0.2:443", []string{"149.154.167.220, 203.0.113.7"}, "", "203.0.113.7"},
+		{"Chain of trusted proxies", "10.0.0.2:443", []string{"149.154.167.220, 192.0.2.1", "10.0.0.3"}, "", "149.154.167.220"},
+		{"Only trusted proxies", "10.0.0.2:443", []string{"10.0.0.3"}, "149.154.167.220", "10.0.0.3"},
+		{"Real IP", "10.0.0.2:443", nil, "149.154.167.220", "149.154.167.220"},
+		{"No headers", "10.0.0.2:443", nil, "", "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+			request.RemoteAddr = tt.remoteAddr
+			request.Header["X-Forwarded-For"] = tt.forwarded
+			if tt.realIP != "" {
+				request.Header.Set("X-Real-IP", tt.realIP)
+			}
+
+			if got := clientIP(request); got != tt.want {
+				t.Errorf("clientIP() = %v, want %v", got, tt.want)
+			}
+
+		})
+	}
+
+}
+
+func TestProxiedUpdatesAreChecked(t *testing.T) {
+
+	defer setWebhookVerification("", true)()
+
+	tests := []struct {
+		name       string
+		proxies    []string
+		forwarded  string
+		wantStatus int
+		wantSent   int
+	}{
+		{
+			name:       "Telegram behind a trusted proxy",
+			proxies:    []string{"10.0.0.0/8"},
+			forwarded:  "149.154.167.220",
+			wantStatus: http.StatusOK,
+			wantSent:   2,
+		},
+		{
+			name:       "Foreign address behind a trusted proxy",
+			proxies:    []string{"10.0.0.0/8"},
+			forwarded:  "203.0.113.7",
+			wantStatus: http.StatusUnauthorized,
+			wantSent:   0,
+		},
+		{
+			name:       "Telegram behind an untrusted proxy",
+			proxies:    nil,
+			forwarded:  "149.154.167.220",
+			wantStatus: http.StatusUnauthorized,
+			wantSent:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			repository.WebhookTrustedProxies = networks.MustParse(tt.proxies...)
+			defer func() { repository.WebhookTrustedProxies = nil }()
+
+			bot := &sendertest.Recorder{}
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(forgedBroadcastUpdate))
+			request.RemoteAddr = "10.0.0.2:54321"
+			request.Header.Set("X-Forwarded-For", tt.forwarded)
+			recorder := httptest.NewRecorder()
+			webhookHandler(bot, newTestDependencies()).ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.wantStatus || len(bot.Sent) != tt.wantSent {
+				t.Errorf("webhookHandler() status = %d with %d requests sent, want %d with %d", recorder.Code, len(bot.Sent), tt.wantStatus, tt.wantSent)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+)
+
+const (
+	// defaultListPeriod is the period of /list without a time range.
+	defaultListPeriod = 7 * 24 * time.Hour
+	// sinceDateFormat is the format of the date after since.
+	sinceDateFormat = "2006-01-02"
+
+	listUsage = "Usage: /list [period | since YYYY-MM-DD] [user ID] [asin ASIN] [domain DOMAIN], " +
+		"e.g. /list 24h, /list 30d user 12345 or /list since 2026-09-01 domain amazon.de. " +
+		"The period defaults to 7 days."
+)
+
+// parseListArguments parses the arguments of /list into the query
+// of the requests to list. The time range is either a period, e.g.
+// 24h or 30d, or a date, and the filters are keywords followed by
+// their value. The errors explain what's wrong to the user.
+func parseListArguments(arguments string, now time.Time) (query persistence.RequestQuery, err error) {
+
+	query.Since = now.Add(-defaultListPeriod).Unix()
+	hasTimeRange := false
+	seen := make(map[string]bool)
+
+	fields := strings.Fields(strings.ToLower(arguments))
+	for index := 0; index < len(fields); index++ {
+
+		keyword := fields[index]
+		if seen[keyword] {
+			return query, errors.Errorf("%s is given more than once.", keyword)
+		}
+		seen[keyword] = true
+
+		switch keyword {
+		case "since", "user", "asin", "domain":
+		default:
+			period, periodErr := parsePeriod(keyword)
+			if periodErr != nil {
+				return query, errors.Errorf("Unknown argument %s.", keyword)
+			}
+			if hasTimeRange {
+				return query, errors.New("Use either a period or since, not both.")
+			}
+			hasTimeRange = true
+			query.Since = now.Add(-period).Unix()
+			continue
+		}
+
+		index++
+		if index == len(fields) {
+			return query, errors.Errorf("Missing value after %s.", keyword)
+		}
+		value := fields[index]
+
+		switch keyword {
+		case "since":
+			if hasTimeRange {
+				return query, errors.New("Use either a period or since, not both.")
+			}
+			hasTimeRange = true
+			date, dateErr := time.Parse(sinceDateFormat, value)
+			if dateErr != nil {
+				return query, errors.Errorf("Invalid date %s, use the YYYY-MM-DD format.", value)
+			}
+			// Since is exclusive, while the date is not.
+			query.Since = date.Unix() - 1
+		case "user":
+			userID, userErr := strconv.Atoi(value)
+			if userErr != nil || userID <= 0 {
+				return query, errors.Errorf("Invalid user ID %s.", value)
+			}
+			query.TelegramID = userID
+		case "asin":
+			asin := strings.ToUpper(value)
+			if !urlwork.IsASIN(asin) {
+				return query, errors.Errorf("Invalid ASIN %s.", asin)
+			}
+			query.ASIN = asin
+		case "domain":
+			marketplace, marketplaceErr := parseMarketplace(value)
+			if marketplaceErr != nil {
+				return query, marketplaceErr
+			}
+			query.Marketplace = marketplace
+		}
+
+	}
+
+	return query, nil
+
+}
+
+// parseMarketplace returns the marketplace of the domain, which must
+// be among the supported ones, e.g. amazon.de for www.amazon.de.
+func parseMarketplace(domain string) (string, error) {
+
+	supported := urlwork.SupportedMarketplaces(repository.ReferralIDs)
+	marketplace := urlwork.MarketplaceOf(domain)
+	for _, candidate := range supported {
+		if marketplace == candidate {
+			return marketplace, nil
+		}
+	}
+
+	return "", errors.Errorf("Unsupported domain %s, use one of %s.", domain, strings.Join(supported, ", "))
+
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+)
+
+func Test_parseListArguments(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "italian-21", "amazon.de": "german-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	lastWeek := now.Add(-7 * 24 * time.Hour).Unix()
+	september := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC).Unix() - 1
+
+	tests := []struct {
+		name      string
+		arguments string
+		want      persistence.RequestQuery
+		wantErr   bool
+	}{
+		{"no arguments", "", persistence.RequestQuery{Since: lastWeek}, false},
+		{"hours", "24h", persistence.RequestQuery{Since: now.Add(-24 * time.Hour).Unix()}, false},
+		{"days", "30D", persistence.RequestQuery{Since: now.Add(-30 * 24 * time.Hour).Unix()}, false},
+		{"since", "since 2026-09-01", persistence.RequestQuery{Since: september}, false},
+		{"user", "user 12345", persistence.RequestQuery{Since: lastWeek, TelegramID: 12345}, false},
+		{"asin", "asin b0794vj18b", persistence.RequestQuery{Since: lastWeek, ASIN: "B0794VJ18B"}, false},
+		{"domain", "domain www.Amazon.de", persistence.RequestQuery{Since: lastWeek, Marketplace: "amazon.de"}, false},
+		{
+			name:      "everything",
+			arguments: "domain amazon.it since 2026-09-01 asin B0794VJ18B user 12345",
+			want:      persistence.RequestQuery{Since: september, TelegramID: 12345, ASIN: "B0794VJ18B", Marketplace: "amazon.it"},
+		},
+		{"period and since", "24h since 2026-09-01", persistence.RequestQuery{}, true},
+		{"two periods", "24h 30d", persistence.RequestQuery{}, true},
+		{"repeated filter", "user 1 user 2", persistence.RequestQuery{}, true},
+		{"missing value", "user", persistence.RequestQuery{}, true},
+		{"invalid date", "since 01/09/2026", persistence.RequestQuery{}, true},
+		{"invalid user", "user me", persistence.RequestQuery{}, true},
+		{"invalid asin", "asin B07", persistence.RequestQuery{}, true},
+		{"unknown argument", "tag mytag-21", persistence.RequestQuery{}, true},
+		{"unsupported domain", "domain amazon.fr", persistence.RequestQuery{}, true},
+		{"not an Amazon domain", "domain example.com", persistence.RequestQuery{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseListArguments(tt.arguments, now)
+			if (err != nil) != tt.wantErr || !tt.wantErr && got != tt.want {
+				t.Errorf("parseListArguments() = %+v, %v, want %+v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+
+}
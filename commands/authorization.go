@@ -8,13 +8,12 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
-	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
 )
 
-func authorizeUser(userID int) error {
+func authorizeUser(userID int, store persistence.Store) error {
 
 	// We need to make sure the user is an admin.
-	isAdmin, err := persistence.IsUserAdmin(userID, repository.DynamoDBClient)
+	isAdmin, err := store.IsUserAdmin(userID)
 	if err != nil {
 		err = errors.Errorf("retrieveLatestRequest: error while checking user status: %s", err)
 		return err
@@ -7,6 +7,7 @@
 package commands
 
 import (
+	"html"
 	"log"
 	"time"
 
@@ -15,25 +16,29 @@ import (
 	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
-	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
-	"github.com/AlessandroPomponio/serverless-amazon-refbot/utility"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
 )
 
 //HandleCommand handles and performs commands.
-func HandleCommand(msg *tgbotapi.Message, bot *tgbotapi.BotAPI) {
+func HandleCommand(msg *tgbotapi.Message, bot sender.Sender, store persistence.Store) {
 
 	// Use a default reply to mask all the errors to the user,
 	// while logging them for the developer on CloudWatch.
 	reply := "Unable to handle this command 😫"
+	var keyboard *tgbotapi.InlineKeyboardMarkup
 	var err error
 
 	switch msg.Command() {
 	case "start", "help":
 		reply = "Welcome!\nSend me an Amazon link and I'll send you the referral version, if the region is supported."
 	case "list":
-		reply, err = retrieveLatestRequest(msg.From.ID)
+		reply, keyboard, err = retrieveLatestRequest(msg.From.ID, msg.CommandArguments(), store)
+	case "stats":
+		reply, err = retrieveStats(msg.From.ID, msg.CommandArguments(), store)
+	case "export":
+		reply, err = performExport(msg.From.ID, msg.Chat.ID, msg.CommandArguments(), bot, store)
 	case "broadcast":
-		reply, err = performBroadcast(msg.From.ID, msg.CommandArguments(), bot)
+		reply, err = performBroadcast(msg.From.ID, msg.CommandArguments(), bot, store)
 	}
 
 	if err != nil {
@@ -42,44 +47,52 @@ func HandleCommand(msg *tgbotapi.Message, bot *tgbotapi.BotAPI) {
 
 	message := tgbotapi.NewMessage(msg.Chat.ID, reply)
 	message.ParseMode = "HTML"
+	if keyboard != nil {
+		message.ReplyMarkup = keyboard
+	}
 	_, _ = bot.Send(message)
 	return
 
 }
 
-// retrieveLatestRequest returns the list of the requests that took
-// place in the last 7 days.
-func retrieveLatestRequest(userID int) (reply string, err error) {
+// retrieveLatestRequest returns the first page of the requests
+// selected by the arguments, by default the ones that took place
+// in the last 7 days, with the keyboard to move between pages.
+func retrieveLatestRequest(userID int, arguments string, store persistence.Store) (reply string, keyboard *tgbotapi.InlineKeyboardMarkup, err error) {
+
+	err = authorizeUser(userID, store)
+	if err != nil {
+		return
+	}
 
-	err = authorizeUser(userID)
+	query, err := parseListArguments(arguments, time.Now())
 	if err != nil {
+		reply = html.EscapeString(err.Error() + "\n\n" + listUsage)
+		err = errors.Errorf("retrieveLatestRequest: %s", err)
 		return
 	}
 
-	// 168 hours in a week, add with a minus to go back in time.
-	lastWeek := time.Now().Add(-168 * time.Hour).Unix()
-	requests, err := persistence.GetRequestsSince(lastWeek, repository.DynamoDBClient)
+	reply, pageKeyboard, err := listPage(query, 0, "", "", store)
 	if err != nil {
 		err = errors.New("Unable to retrieve requests")
 		return
 	}
 
-	reply = utility.FormatRequests(requests)
-	return
+	return reply, &pageKeyboard, nil
 
 }
 
 // performBroadcast sends a message to all the users in the database that
 // didn't block the bot. It will only send a message every 50ms in order
 // not to get limited by Telegram.
-func performBroadcast(userID int, messageToSend string, bot *tgbotapi.BotAPI) (reply string, err error) {
+func performBroadcast(userID int, messageToSend string, bot sender.Sender, store persistence.Store) (reply string, err error) {
 
-	err = authorizeUser(userID)
+	err = authorizeUser(userID, store)
 	if err != nil {
 		return
 	}
 
-	users, err := persistence.GetAllUsers(repository.DynamoDBClient)
+	users, err := store.GetAllUsers()
 	if err != nil {
 		err = errors.New("performBroadcast: unable to retrieve users")
 		return
@@ -91,7 +104,7 @@ func performBroadcast(userID int, messageToSend string, bot *tgbotapi.BotAPI) (r
 		// Bots can send up to 30 messages per seconds.
 		response, _ := bot.Request(tgbotapi.NewMessage(int64(user.TelegramID), messageToSend))
 		if response.ErrorCode == 403 {
-			_ = persistence.UpdateUserBlockStatus(user.TelegramID, true, repository.DynamoDBClient)
+			_ = store.UpdateUserBlockStatus(user.TelegramID, true)
 		}
 
 		time.Sleep(50 * time.Millisecond)
@@ -0,0 +1,159 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/utility"
+)
+
+const (
+	// exportPageSize is the number of requests read
+	// from the store at a time while exporting them.
+	exportPageSize = 500
+	// maxExportSize is the maximum size, in bytes, of
+	// the documents bots can send to Telegram.
+	maxExportSize = 50 * 1024 * 1024
+
+	exportUsage = "Usage: /export [period | since YYYY-MM-DD] [user ID] [asin ASIN] [domain DOMAIN] [csv | jsonl], " +
+		"e.g. /export 30d or /export since 2026-09-01 jsonl. The period defaults to 7 days and the format to csv."
+)
+
+// performExport sends to the chat a document with the requests
+// selected by the arguments, in the format given as the last
+// argument, CSV by default.
+func performExport(userID int, chatID int64, arguments string, bot sender.Sender, store persistence.Store) (reply string, err error) {
+
+	err = authorizeUser(userID, store)
+	if err != nil {
+		return
+	}
+
+	format := utility.ExportCSV
+	fields := strings.Fields(strings.ToLower(arguments))
+	if last := len(fields) - 1; last >= 0 && (fields[last] == utility.ExportCSV || fields[last] == utility.ExportJSONL) {
+		format = fields[last]
+		fields = fields[:last]
+	}
+
+	query, err := parseListArguments(strings.Join(fields, " "), time.Now())
+	if err != nil {
+		reply = html.EscapeString(err.Error() + "\n\n" + exportUsage)
+		err = errors.Errorf("performExport: %s", err)
+		return
+	}
+
+	// The requests are written to a temporary file,
+	// so that they are never all in memory.
+	file, err := ioutil.TempFile(os.TempDir(), "refbot-export-*."+format)
+	if err != nil {
+		err = errors.Errorf("performExport: unable to create the file: %s", err)
+		return
+	}
+
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	limited := &limitedWriter{writer: file, remaining: maxExportSize}
+	exported, err := exportRequests(query, format, limited, store)
+	if limited.exceeded {
+		return "The export is too large for Telegram, choose a shorter period or add filters", errors.Errorf("performExport: more than %d bytes exported", maxExportSize)
+	}
+
+	if err != nil {
+		err = errors.Errorf("performExport: %s", err)
+		return
+	}
+
+	if exported == 0 {
+		return "No requests to export", nil
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		err = errors.Errorf("performExport: unable to read the file: %s", err)
+		return
+	}
+
+	upload := tgbotapi.NewDocumentUpload(chatID, tgbotapi.FileReader{
+		Name:   fmt.Sprintf("requests-%s.%s", time.Now().UTC().Format("20060102-150405"), format),
+		Reader: file,
+		Size:   maxExportSize - limited.remaining,
+	})
+	upload.Caption = describeQuery(query)
+
+	_, err = bot.Send(upload)
+	if err != nil {
+		err = errors.Errorf("performExport: unable to send the document: %s", err)
+		return
+	}
+
+	return fmt.Sprintf("Exported %d requests", exported), nil
+
+}
+
+// exportRequests writes the requests selected by the query in
+// the format and returns the number of requests written.
+func exportRequests(query persistence.RequestQuery, format string, writer io.Writer, store persistence.Store) (exported int, err error) {
+
+	encoder, err := utility.NewRequestEncoder(format, writer)
+	if err != nil {
+		err = errors.Errorf("exportRequests: %s", err)
+		return
+	}
+
+	err = persistence.ForEachRequest(store, query, exportPageSize, func(request structs.Request) error {
+		exported++
+		return encoder.Encode(request)
+	})
+	if err != nil {
+		err = errors.Errorf("exportRequests: %s", err)
+		return
+	}
+
+	err = encoder.Flush()
+	if err != nil {
+		err = errors.Errorf("exportRequests: %s", err)
+	}
+
+	return
+
+}
+
+// limitedWriter is an io.Writer that fails once
+// more than the remaining bytes are written.
+type limitedWriter struct {
+	writer    io.Writer
+	remaining int64
+	exceeded  bool
+}
+
+// Write writes the bytes, unless there are more than the remaining ones.
+func (l *limitedWriter) Write(p []byte) (int, error) {
+
+	if int64(len(p)) > l.remaining {
+		l.exceeded = true
+		return 0, errors.Errorf("more than %d bytes written", l.remaining)
+	}
+
+	l.remaining -= int64(len(p))
+	return l.writer.Write(p)
+
+}
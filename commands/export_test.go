@@ -0,0 +1,98 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender/sendertest"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/utility"
+)
+
+func Test_performExport(t *testing.T) {
+
+	store := newListStore(t, 25)
+
+	tests := []struct {
+		name      string
+		userID    int
+		arguments string
+		wantFile  string
+		wantLines int
+		wantErr   bool
+	}{
+		{"CSV", 1, "since 1970-01-01", ".csv", 26, false},
+		{"JSONL", 1, "since 1970-01-01 JSONL", ".jsonl", 25, false},
+		{"filters", 1, "since 1970-01-01 user 101 csv", ".csv", 2, false},
+		{"no requests", 1, "", "", 0, false},
+		{"invalid arguments", 1, "csv jsonl", "", 0, true},
+		{"not an admin", 2, "since 1970-01-01", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			// The document is read while it's sent,
+			// since its file is removed afterwards.
+			var content []byte
+			bot := &sendertest.Recorder{SendErr: func(c tgbotapi.Chattable) (err error) {
+				if document, ok := c.(tgbotapi.DocumentConfig); ok {
+					content, err = ioutil.ReadAll(document.File.(tgbotapi.FileReader).Reader)
+				}
+				return
+			}}
+			reply, err := performExport(tt.userID, 1, tt.arguments, bot, store)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("performExport() = %v, %v, wantErr %v", reply, err, tt.wantErr)
+			}
+
+			if tt.wantFile == "" {
+				if len(bot.Sent) != 0 {
+					t.Errorf("performExport() sent %+v, want nothing", bot.Sent)
+				}
+				return
+			}
+
+			if len(bot.Sent) != 1 {
+				t.Fatalf("performExport() sent %+v, want a document", bot.Sent)
+			}
+
+			document := bot.Sent[0].(tgbotapi.DocumentConfig)
+			file := document.File.(tgbotapi.FileReader)
+			if document.ChatID != 1 || !strings.HasSuffix(file.Name, tt.wantFile) {
+				t.Errorf("performExport() sent %s to %d, want a %s file to 1", file.Name, document.ChatID, tt.wantFile)
+			}
+
+			if file.Size != int64(len(content)) {
+				t.Errorf("performExport() sent %d bytes, want %d", len(content), file.Size)
+			}
+
+			if lines := bytes.Count(content, []byte("\n")); lines != tt.wantLines {
+				t.Errorf("performExport() sent %d lines, want %d", lines, tt.wantLines)
+			}
+
+		})
+	}
+
+}
+
+func Test_exportRequests_limit(t *testing.T) {
+
+	store := newListStore(t, 25)
+
+	var buffer bytes.Buffer
+	limited := &limitedWriter{writer: &buffer, remaining: 100}
+	_, err := exportRequests(persistence.RequestQuery{}, utility.ExportJSONL, limited, store)
+	if err == nil || !limited.exceeded || buffer.Len() > 100 {
+		t.Errorf("exportRequests() error = %v, wrote %d bytes, want to stop before 100", err, buffer.Len())
+	}
+
+}
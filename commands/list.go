@@ -0,0 +1,232 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/utility"
+)
+
+const (
+	// listPageSize is the number of requests in a page of /list.
+	listPageSize = 10
+	// listCallbackPrefix is the prefix of the callback
+	// data of the buttons of the /list pages.
+	listCallbackPrefix = "list"
+	// maxCallbackDataLength is the maximum length,
+	// in bytes, Telegram allows for the callback data.
+	maxCallbackDataLength = 64
+	// marketplacePrefix is the common prefix of the
+	// marketplaces, left out of the callback data.
+	marketplacePrefix = "amazon."
+)
+
+// HandleCallbackQuery handles the buttons of the /list pages,
+// editing the message with the requested page.
+func HandleCallbackQuery(callback *tgbotapi.CallbackQuery, bot sender.Sender, store persistence.Store) {
+
+	// Always answer, to stop the progress bar on the button.
+	defer func() {
+		_, _ = bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+	}()
+
+	if callback.Message == nil || !strings.HasPrefix(callback.Data, listCallbackPrefix+":") {
+		log.Println("HandleCallbackQuery: ignoring callback query with data:", callback.Data)
+		return
+	}
+
+	err := authorizeUser(callback.From.ID, store)
+	if err != nil {
+		log.Println("HandleCallbackQuery: error:", err)
+		return
+	}
+
+	query, page, cursor, previous, err := decodeListData(callback.Data)
+	if err != nil {
+		log.Println("HandleCallbackQuery: error:", err)
+		return
+	}
+
+	text, keyboard, err := listPage(query, page, cursor, previous, store)
+	if err != nil {
+		log.Println("HandleCallbackQuery: error:", err)
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(callback.Message.Chat.ID, callback.Message.MessageID, text)
+	edit.ParseMode = "HTML"
+	edit.ReplyMarkup = &keyboard
+
+	_, err = bot.Send(edit)
+	if err != nil {
+		log.Println("HandleCallbackQuery: unable to edit the message:", err)
+	}
+
+}
+
+// listPage returns the text of a page of the requests selected by
+// the query and the keyboard to move to the previous and next ones.
+// The cursor is the one the page starts from, and previous the one
+// of the page before, empty for the first two pages or if unknown.
+func listPage(query persistence.RequestQuery, page int, cursor string, previous string, store persistence.Store) (text string, keyboard tgbotapi.InlineKeyboardMarkup, err error) {
+
+	// Without the cursor, e.g. after going back twice,
+	// the page is found reading from the first one.
+	if cursor == "" && page > 0 {
+		page, cursor, previous, err = seekPage(query, page, store)
+		if err != nil {
+			err = errors.Errorf("listPage: unable to retrieve requests: %s", err)
+			return
+		}
+	}
+
+	requests, next, err := store.GetRequestsPage(query, cursor, listPageSize)
+	if err != nil {
+		err = errors.Errorf("listPage: unable to retrieve requests: %s", err)
+		return
+	}
+
+	text = fmt.Sprintf("<b>%s, page %d</b>\n\n", html.EscapeString(describeQuery(query)), page+1) + utility.FormatRequests(requests)
+
+	var buttons []tgbotapi.InlineKeyboardButton
+	if page > 0 {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("⬅️ Previous", encodeListData(query, page-1, previous, "")))
+	}
+
+	if next != "" {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData("Next ➡️", encodeListData(query, page+1, next, cursor)))
+	}
+
+	keyboard = tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}
+	if len(buttons) > 0 {
+		keyboard = tgbotapi.NewInlineKeyboardMarkup(buttons)
+	}
+
+	return
+
+}
+
+// seekPage reads the pages from the first one to find the cursor
+// of a page and of the one before. If the page is past the last one,
+// the cursors of the last one are returned, along with its number.
+func seekPage(query persistence.RequestQuery, page int, store persistence.Store) (found int, cursor string, previous string, err error) {
+
+	for found < page {
+
+		var next string
+		_, next, err = store.GetRequestsPage(query, cursor, listPageSize)
+		if err != nil || next == "" {
+			return
+		}
+
+		previous, cursor = cursor, next
+		found++
+
+	}
+
+	return
+
+}
+
+// describeQuery returns the description of the requests selected by the query.
+func describeQuery(query persistence.RequestQuery) string {
+
+	description := "Requests since " + utility.FormatDate(time.Unix(query.Since, 0))
+	if query.TelegramID != 0 {
+		description += fmt.Sprintf(" by user %d", query.TelegramID)
+	}
+
+	if query.ASIN != "" {
+		description += " for " + query.ASIN
+	}
+
+	if query.Marketplace != "" {
+		description += " on " + query.Marketplace
+	}
+
+	return description
+
+}
+
+// encodeListData returns the callback data of the button to a page:
+// the prefix, the page, the query and the cursors of the page and of
+// the previous one, separated by colons. The numbers are in base 36 so
+// that the data fits in the 64 bytes Telegram allows. If it doesn't,
+// the cursor of the previous page is left out, then the one of the
+// page, which is then read from the first.
+func encodeListData(query persistence.RequestQuery, page int, cursor string, previous string) string {
+
+	// The cursors of the imported requests may contain colons.
+	if strings.Contains(previous, ":") {
+		previous = ""
+	}
+
+	if strings.Contains(cursor, ":") {
+		cursor = ""
+	}
+
+	data := strings.Join([]string{
+		listCallbackPrefix,
+		strconv.FormatInt(int64(page), 36),
+		strconv.FormatInt(query.Since, 36),
+		strconv.FormatInt(int64(query.TelegramID), 36),
+		query.ASIN,
+		strings.TrimPrefix(query.Marketplace, marketplacePrefix),
+		cursor,
+		previous,
+	}, ":")
+
+	if len(data) > maxCallbackDataLength && previous != "" {
+		return encodeListData(query, page, cursor, "")
+	}
+
+	if len(data) > maxCallbackDataLength && cursor != "" {
+		return encodeListData(query, page, "", "")
+	}
+
+	return data
+
+}
+
+// decodeListData parses the callback data of the button to a page.
+func decodeListData(data string) (query persistence.RequestQuery, page int, cursor string, previous string, err error) {
+
+	fields := strings.Split(data, ":")
+	if len(fields) != 8 || fields[0] != listCallbackPrefix {
+		err = errors.Errorf("decodeListData: invalid data %s", data)
+		return
+	}
+
+	parsedPage, pageErr := strconv.ParseInt(fields[1], 36, 32)
+	since, sinceErr := strconv.ParseInt(fields[2], 36, 64)
+	telegramID, idErr := strconv.ParseInt(fields[3], 36, 64)
+	if pageErr != nil || sinceErr != nil || idErr != nil || parsedPage < 0 || telegramID < 0 {
+		err = errors.Errorf("decodeListData: invalid data %s", data)
+		return
+	}
+
+	page = int(parsedPage)
+	query.Since = since
+	query.TelegramID = int(telegramID)
+	query.ASIN = fields[4]
+	if fields[5] != "" {
+		query.Marketplace = marketplacePrefix + fields[5]
+	}
+
+	cursor, previous = fields[6], fields[7]
+	return
+
+}
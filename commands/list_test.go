@@ -0,0 +1,186 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender/sendertest"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// newListStore returns a store with an admin,
+// whose ID is 1, and the given number of requests.
+func newListStore(t *testing.T, requests int) *persistence.MemoryStore {
+
+	store := persistence.NewMemoryStore(1)
+	for i := 1; i <= requests; i++ {
+		request := structs.Request{XID: fmt.Sprintf("request%02d", i), TelegramID: 100 + i, URL: "https://amzn.to/", Time: time.Unix(int64(i*100), 0)}
+		if err := store.PutRequest(request); err != nil {
+			t.Fatalf("PutRequest() error = %v", err)
+		}
+	}
+
+	return store
+
+}
+
+func Test_encodeListData(t *testing.T) {
+
+	since := persistence.RequestQuery{Since: 1577836800}
+	filtered := persistence.RequestQuery{Since: 1577836800, TelegramID: 12345, ASIN: "B0794VJ18B", Marketplace: "amazon.de"}
+	largest := persistence.RequestQuery{Since: 1577836800, TelegramID: 1 << 40, ASIN: "B0794VJ18B", Marketplace: "amazon.com.au"}
+	cursor, previous := "bq1fnbqh5ugg00c4k2ng", "bq1fnbqh5ugg00c4k2mg"
+
+	tests := []struct {
+		name         string
+		query        persistence.RequestQuery
+		page         int
+		cursor       string
+		previous     string
+		wantCursor   string
+		wantPrevious string
+	}{
+		{"first page", since, 0, "", "", "", ""},
+		{"with cursors", since, 3, cursor, previous, cursor, previous},
+		{"with filters", filtered, 1, cursor, previous, cursor, ""},
+		{"not XID cursors", since, 2, "request20", "request10", "request20", "request10"},
+		{"cursors with colons", since, 2, "request:20", "request:10", "", ""},
+		{"ISBN", persistence.RequestQuery{ASIN: "030640615X"}, 1, cursor, "", cursor, ""},
+		{"largest query", largest, 1000, cursor, previous, cursor, ""},
+		{"cursor too long", since, 3, strings.Repeat("x", 60), "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := encodeListData(tt.query, tt.page, tt.cursor, tt.previous)
+			if len(got) > maxCallbackDataLength || !strings.HasPrefix(got, listCallbackPrefix+":") {
+				t.Fatalf("encodeListData() = %v, want at most %d bytes", got, maxCallbackDataLength)
+			}
+
+			gotQuery, gotPage, gotCursor, gotPrevious, err := decodeListData(got)
+			if err != nil || gotQuery != tt.query || gotPage != tt.page || gotCursor != tt.wantCursor || gotPrevious != tt.wantPrevious {
+				t.Errorf("decodeListData() = %v, %v, %v, %v, %v, want %v, %v, %v, %v, nil", gotQuery, gotPage, gotCursor, gotPrevious, err, tt.query, tt.page, tt.wantCursor, tt.wantPrevious)
+			}
+
+		})
+	}
+
+	valid := encodeListData(filtered, 1, cursor, "")
+	for _, data := range []string{"list", "list:", "list:1:2:3", "other:" + strings.TrimPrefix(valid, "list:"), valid + ":", "list:-1:0:0::::", "list:!:0:0::::", "list:0:0:-1::::"} {
+		if _, _, _, _, err := decodeListData(data); err == nil {
+			t.Errorf("decodeListData(%s) returned no error", data)
+		}
+	}
+
+}
+
+func Test_listPage(t *testing.T) {
+
+	store := newListStore(t, 25)
+
+	// buttonsData returns the pages of the buttons,
+	// as <page>:<cursor>:<previous>.
+	buttonsData := func(keyboard tgbotapi.InlineKeyboardMarkup) (data []string) {
+		for _, row := range keyboard.InlineKeyboard {
+			for _, button := range row {
+				_, page, cursor, previous, err := decodeListData(*button.CallbackData)
+				if err != nil {
+					t.Fatalf("decodeListData() error = %v", err)
+				}
+				data = append(data, fmt.Sprintf("%d:%s:%s", page, cursor, previous))
+			}
+		}
+		return data
+	}
+
+	tests := []struct {
+		name      string
+		page      int
+		cursor    string
+		previous  string
+		wantPage  int
+		wantFirst int
+		wantData  []string
+	}{
+		{"first page", 0, "", "", 0, 101, []string{"1:request10:"}},
+		{"next page", 1, "request10", "", 1, 111, []string{"0::", "2:request20:request10"}},
+		{"previous page", 1, "", "", 1, 111, []string{"0::", "2:request20:request10"}},
+		{"last page", 2, "request20", "request10", 2, 121, []string{"1:request10:"}},
+		{"last page without cursors", 2, "", "", 2, 121, []string{"1:request10:"}},
+		{"past the last page", 5, "", "", 2, 121, []string{"1:request10:"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			text, keyboard, err := listPage(persistence.RequestQuery{}, tt.page, tt.cursor, tt.previous, store)
+			if err != nil {
+				t.Fatalf("listPage() error = %v", err)
+			}
+
+			if !strings.Contains(text, fmt.Sprintf("page %d</b>", tt.wantPage+1)) || !strings.Contains(text, fmt.Sprintf("id=%d\"", tt.wantFirst)) {
+				t.Errorf("listPage() = %v, want page %d starting from %d", text, tt.wantPage+1, tt.wantFirst)
+			}
+
+			if got := buttonsData(keyboard); !reflect.DeepEqual(got, tt.wantData) {
+				t.Errorf("listPage() buttons = %v, want %v", got, tt.wantData)
+			}
+
+		})
+	}
+
+}
+
+func TestHandleCallbackQuery(t *testing.T) {
+
+	store := newListStore(t, 15)
+	message := &tgbotapi.Message{MessageID: 7, Chat: &tgbotapi.Chat{ID: 1, Type: "private"}}
+
+	tests := []struct {
+		name     string
+		userID   int
+		data     string
+		wantEdit bool
+	}{
+		{"admin", 1, encodeListData(persistence.RequestQuery{}, 1, "request10", ""), true},
+		{"not an admin", 2, encodeListData(persistence.RequestQuery{}, 1, "request10", ""), false},
+		{"unknown data", 1, "other", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			bot := &sendertest.Recorder{}
+			HandleCallbackQuery(&tgbotapi.CallbackQuery{ID: "42", From: &tgbotapi.User{ID: tt.userID}, Message: message, Data: tt.data}, bot, store)
+
+			// The callback query is always answered, after the edit.
+			if len(bot.Sent) == 0 || !reflect.DeepEqual(bot.Sent[len(bot.Sent)-1], tgbotapi.NewCallback("42", "")) {
+				t.Errorf("HandleCallbackQuery() sent %+v, want the answer last", bot.Sent)
+			}
+
+			if gotEdit := len(bot.Sent) == 2; gotEdit != tt.wantEdit {
+				t.Fatalf("HandleCallbackQuery() sent %+v, want edit %v", bot.Sent, tt.wantEdit)
+			}
+
+			if tt.wantEdit {
+				edit := bot.Sent[0].(tgbotapi.EditMessageTextConfig)
+				if edit.ChatID != 1 || edit.MessageID != 7 || !strings.Contains(edit.Text, "page 2") || edit.ReplyMarkup == nil {
+					t.Errorf("HandleCallbackQuery() edit = %+v, want page 2 of message 7", edit)
+				}
+			}
+
+		})
+	}
+
+}
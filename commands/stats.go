@@ -0,0 +1,85 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/utility"
+)
+
+const (
+	// defaultStatsPeriod is the period of /stats without arguments.
+	defaultStatsPeriod = 7 * 24 * time.Hour
+	// maxStatsPeriod is the longest period of /stats, so
+	// that the requests per day fit in a single message.
+	maxStatsPeriod = 31 * 24 * time.Hour
+	// statsTop is the number of top products and marketplaces.
+	statsTop = 5
+
+	statsUsage = "Usage: /stats [period], e.g. /stats 24h or /stats 30d. The period can be up to 31 days and defaults to 7 days."
+)
+
+// retrieveStats returns the statistics of the requests
+// made in the period given in the arguments.
+func retrieveStats(userID int, arguments string, store persistence.Store) (reply string, err error) {
+
+	err = authorizeUser(userID, store)
+	if err != nil {
+		return
+	}
+
+	period := defaultStatsPeriod
+	if arguments = strings.TrimSpace(arguments); arguments != "" {
+		period, err = parsePeriod(arguments)
+		if err != nil {
+			return statsUsage, err
+		}
+	}
+
+	if period > maxStatsPeriod {
+		return statsUsage, errors.Errorf("retrieveStats: period %s is too long", arguments)
+	}
+
+	stats, err := persistence.GetRequestStats(store, time.Now().Add(-period), statsTop)
+	if err != nil {
+		err = errors.Errorf("retrieveStats: %s", err)
+		return
+	}
+
+	reply = utility.FormatStats(stats)
+	return
+
+}
+
+// parsePeriod parses a period given as a number of
+// hours, days or weeks, e.g. 24h, 30d or 2w.
+func parsePeriod(period string) (time.Duration, error) {
+
+	units := map[byte]time.Duration{
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+
+	period = strings.ToLower(period)
+	if len(period) < 2 {
+		return 0, errors.Errorf("parsePeriod: invalid period %s", period)
+	}
+
+	unit, found := units[period[len(period)-1]]
+	number, err := strconv.Atoi(period[:len(period)-1])
+	if !found || err != nil || number <= 0 {
+		return 0, errors.Errorf("parsePeriod: invalid period %s", period)
+	}
+
+	return time.Duration(number) * unit, nil
+
+}
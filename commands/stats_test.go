@@ -0,0 +1,37 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_parsePeriod(t *testing.T) {
+
+	tests := []struct {
+		period  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"24h", 24 * time.Hour, false},
+		{"30d", 30 * 24 * time.Hour, false},
+		{"2W", 14 * 24 * time.Hour, false},
+		{"d", 0, true},
+		{"0d", 0, true},
+		{"-1d", 0, true},
+		{"10m", 0, true},
+		{"week", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			got, err := parsePeriod(tt.period)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parsePeriod() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
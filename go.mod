@@ -3,10 +3,10 @@ module github.com/AlessandroPomponio/serverless-amazon-refbot
 go 1.13
 
 require (
-	github.com/aws/aws-lambda-go v1.14.0
+	github.com/aws/aws-lambda-go v1.19.1
 	github.com/aws/aws-sdk-go v1.29.9
 	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.0.0-rc1
 	github.com/pkg/errors v0.9.1
-	github.com/retgits/bitly v0.0.0-20190406234342-3bba0c6aad8f
 	github.com/rs/xid v1.2.1
+	go.etcd.io/bbolt v1.3.6
 )
@@ -0,0 +1,285 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+)
+
+const (
+	// importCommand is the command line argument
+	// that runs the import instead of the bot.
+	importCommand = "import"
+	// defaultImportBatchSize is the default number of requests
+	// written at a time, the most a DynamoDB batch can hold.
+	defaultImportBatchSize = 25
+	// maxImportLineSize is the maximum size, in bytes, of a record.
+	maxImportLineSize = 1 << 20
+)
+
+// importError is the error of a line of the imported file.
+type importError struct {
+	Line int
+	Err  error
+}
+
+func (e importError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+// importReport is the outcome of an import.
+type importReport struct {
+	// Read is the number of records read.
+	Read int
+	// Imported is the number of requests saved,
+	// or that would be saved in a dry run.
+	Imported int
+	// Errors are the errors of the lines that were not imported.
+	Errors []importError
+}
+
+// runImport imports the requests of a JSONL file, or of the standard
+// input, into the configured store and returns the exit status.
+// Usage: import [-dry-run] [-batch-size n] [file.jsonl]
+func runImport(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+
+	flags := flag.NewFlagSet(importCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [-dry-run] [-batch-size n] [file.jsonl]\n", importCommand)
+		fmt.Fprintln(flags.Output(), "Imports the requests of the JSONL file, or of the standard input, into the configured storage.")
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "validate the requests without saving them")
+	batchSize := flags.Int("batch-size", defaultImportBatchSize, "number of requests saved at a time")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return 2
+	}
+
+	if flags.NArg() > 1 || *batchSize < 1 {
+		flags.Usage()
+		return 2
+	}
+
+	input := stdin
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(stderr, "runImport:", err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	var store persistence.Store
+	if !*dryRun {
+		repository.LoadStorageVariables()
+		var closeStore func() error
+		store, closeStore, err = openImportStore()
+		if err != nil {
+			fmt.Fprintln(stderr, "runImport:", err)
+			return 1
+		}
+		defer closeStore()
+	}
+
+	report, err := importRequests(input, store, *batchSize, *dryRun)
+	for _, lineErr := range report.Errors {
+		fmt.Fprintln(stderr, lineErr)
+	}
+
+	if err != nil {
+		fmt.Fprintln(stderr, "runImport:", err)
+	}
+
+	verb := "imported"
+	if *dryRun {
+		verb = "would be imported"
+	}
+	fmt.Fprintf(stdout, "%d requests read: %d %s, %d failed\n", report.Read, report.Imported, verb, len(report.Errors))
+
+	if err != nil || len(report.Errors) > 0 {
+		return 1
+	}
+
+	return 0
+
+}
+
+// openImportStore returns the configured store and the function to
+// close it. Unlike newStore, it doesn't fall back to the in-memory
+// store, as the imported requests would be lost.
+func openImportStore() (persistence.Store, func() error, error) {
+
+	switch repository.StorageBackend {
+	case repository.StorageMemory:
+		return nil, nil, errors.Errorf("openImportStore: the %s storage backend doesn't keep the requests", repository.StorageMemory)
+	case repository.StorageBolt:
+		store, err := persistence.NewBoltStore(repository.BoltDBPath)
+		if err != nil {
+			return nil, nil, errors.Errorf("openImportStore: %s", err)
+		}
+		return store, store.Close, nil
+	}
+
+	err := repository.CreateAWSSession()
+	if err != nil {
+		return nil, nil, errors.Errorf("openImportStore: unable to create AWS session: %s", err)
+	}
+
+	return persistence.NewDynamoDBStore(dynamodb.New(repository.AWSSession)), func() error { return nil }, nil
+
+}
+
+// importRequests reads the requests from the JSONL reader, one per
+// line, validates them and saves them in batches. The invalid lines
+// and the ones of the batches that could not be saved are reported,
+// while the others are still imported. The errors are sorted by
+// line. In a dry run, the requests
+// are only validated and the store is not used.
+func importRequests(reader io.Reader, store persistence.Store, batchSize int, dryRun bool) (report importReport, err error) {
+
+	var batch []structs.Request
+	var batchLines []int
+
+	flush := func() {
+
+		if len(batch) == 0 {
+			return
+		}
+
+		if !dryRun {
+			if putErr := store.PutRequests(batch); putErr != nil {
+				for _, line := range batchLines {
+					report.Errors = append(report.Errors, importError{Line: line, Err: putErr})
+				}
+				batch, batchLines = batch[:0], batchLines[:0]
+				return
+			}
+		}
+
+		report.Imported += len(batch)
+		batch, batchLines = batch[:0], batchLines[:0]
+
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+	seenXIDs := make(map[string]int)
+	now := time.Now()
+
+	for line := 1; scanner.Scan(); line++ {
+
+		record := bytes.TrimSpace(scanner.Bytes())
+		if len(record) == 0 {
+			continue
+		}
+		report.Read++
+
+		request, parseErr := parseImportedRequest(record, now)
+		if parseErr != nil {
+			report.Errors = append(report.Errors, importError{Line: line, Err: parseErr})
+			continue
+		}
+
+		if previous, found := seenXIDs[request.XID]; found {
+			report.Errors = append(report.Errors, importError{Line: line, Err: errors.Errorf("XID %s already used on line %d", request.XID, previous)})
+			continue
+		}
+		seenXIDs[request.XID] = line
+
+		batch = append(batch, request)
+		batchLines = append(batchLines, line)
+		if len(batch) == batchSize {
+			flush()
+		}
+
+	}
+
+	flush()
+
+	// The errors of the failed batches come after the
+	// ones of the invalid lines read in the meantime.
+	sort.Slice(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Line < report.Errors[j].Line
+	})
+
+	err = scanner.Err()
+	if err != nil {
+		err = errors.Errorf("importRequests: unable to read the requests: %s", err)
+	}
+
+	return
+
+}
+
+// parseImportedRequest decodes and validates a request. Its fields
+// are the ones of structs.Request: TelegramID, URL and either Time
+// or UnixTime are required, while the missing XID is assigned.
+func parseImportedRequest(record []byte, now time.Time) (request structs.Request, err error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(record))
+	decoder.DisallowUnknownFields()
+
+	err = decoder.Decode(&request)
+	if err != nil {
+		return request, errors.Errorf("invalid JSON: %s", err)
+	}
+
+	if decoder.More() {
+		return request, errors.New("more than one JSON value")
+	}
+
+	if request.TelegramID <= 0 {
+		return request, errors.New("missing or invalid TelegramID")
+	}
+
+	parsedURL, err := url.Parse(request.URL)
+	if request.URL == "" || err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return request, errors.Errorf("missing or invalid URL %q", request.URL)
+	}
+
+	switch {
+	case request.Time.IsZero() && request.UnixTime == 0:
+		return request, errors.New("missing Time and UnixTime")
+	case request.Time.IsZero():
+		request.Time = time.Unix(request.UnixTime, 0).UTC()
+	case request.UnixTime != 0 && request.UnixTime != request.Time.Unix():
+		return request, errors.Errorf("UnixTime %d doesn't match Time %s", request.UnixTime, request.Time.Format(time.RFC3339))
+	}
+
+	if request.Time.After(now) {
+		return request, errors.Errorf("Time %s is in the future", request.Time.Format(time.RFC3339))
+	}
+
+	if request.ASIN != "" && !urlwork.IsASIN(request.ASIN) {
+		return request, errors.Errorf("invalid ASIN %s", request.ASIN)
+	}
+
+	request.Marketplace = strings.TrimPrefix(strings.ToLower(request.Marketplace), "www.")
+	return persistence.NewRequest(request), nil
+
+}
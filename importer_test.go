@@ -0,0 +1,131 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// importedRequests has a valid request on lines 1, 2, 5 and 9.
+const importedRequests = `{"XID":"old1","TelegramID":12345,"URL":"https://amzn.to/2abcdef","Marketplace":"www.Amazon.it","ASIN":"B0794VJ18B","Time":"2026-09-01T10:30:00Z"}
+{"TelegramID":12345,"URL":"https://www.amazon.de/dp/B00TEST123?tag=mytag-21","UnixTime":1788346800}
+
+{"XID":"old1","TelegramID":1,"URL":"https://amzn.to/x","UnixTime":1}
+{"XID":"old5","TelegramID":67890,"URL":"https://amzn.to/y","Time":"2026-09-03T08:00:00+02:00","UnixTime":1788415200}
+{"TelegramID":0,"URL":"https://amzn.to/z","UnixTime":1}
+{"TelegramID":1,"URL":"amzn.to/z","UnixTime":1}
+{"TelegramID":1,"URL":"https://amzn.to/z","UnixTime":1,"Tag":"mytag-21"}
+{"TelegramID":1,"URL":"https://amzn.to/z","UnixTime":1}
+{"TelegramID":1,"URL":"https://amzn.to/z"}
+{"TelegramID":1,"URL":"https://amzn.to/z","Time":"2026-09-01T10:30:00Z","UnixTime":1}
+{"TelegramID":1,"URL":"https://amzn.to/z","UnixTime":1,"ASIN":"B07"}
+{"TelegramID":1,"URL":"https://amzn.to/z","Time":"2999-01-01T00:00:00Z"}
+not json
+`
+
+// failingStore is a Store whose batched writes always fail.
+type failingStore struct {
+	persistence.Store
+}
+
+func (failingStore) PutRequests(requests []structs.Request) error {
+	return errors.New("throttled")
+}
+
+func Test_importRequests(t *testing.T) {
+
+	wantErrorLines := []int{4, 6, 7, 8, 10, 11, 12, 13, 14}
+
+	// errorLines returns the lines of the errors of the report.
+	errorLines := func(report importReport) (lines []int) {
+		for _, lineErr := range report.Errors {
+			lines = append(lines, lineErr.Line)
+		}
+		return lines
+	}
+
+	t.Run("import", func(t *testing.T) {
+
+		store := persistence.NewMemoryStore()
+		report, err := importRequests(strings.NewReader(importedRequests), store, 2, false)
+		if err != nil || report.Read != 13 || report.Imported != 4 || !reflect.DeepEqual(errorLines(report), wantErrorLines) {
+			t.Fatalf("importRequests() = %+v, %v, want 13 read, 4 imported and errors on lines %v", report, err, wantErrorLines)
+		}
+
+		requests, _, _ := store.GetRequestsPage(persistence.RequestQuery{}, "", 10)
+		if len(requests) != 4 {
+			t.Fatalf("importRequests() saved %+v, want 4 requests", requests)
+		}
+
+		first := requests[0]
+		if first.XID != "old1" || first.Marketplace != "amazon.it" || first.UnixTime != first.Time.Unix() {
+			t.Errorf("importRequests() saved %+v, want XID old1 on amazon.it", first)
+		}
+
+		if second := requests[1]; second.XID == "" || second.Time.Unix() != 1788346800 {
+			t.Errorf("importRequests() saved %+v, want a new XID and the time of UnixTime", second)
+		}
+
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+
+		report, err := importRequests(strings.NewReader(importedRequests), nil, 2, true)
+		if err != nil || report.Imported != 4 || !reflect.DeepEqual(errorLines(report), wantErrorLines) {
+			t.Errorf("importRequests() = %+v, %v, want 4 imported and errors on lines %v", report, err, wantErrorLines)
+		}
+
+	})
+
+	t.Run("failed batches", func(t *testing.T) {
+
+		report, err := importRequests(strings.NewReader(importedRequests), failingStore{}, 3, false)
+		wantLines := []int{1, 2, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}
+		if err != nil || report.Imported != 0 || !reflect.DeepEqual(errorLines(report), wantLines) {
+			t.Errorf("importRequests() = %+v, %v, want no requests imported and errors on lines %v", report, err, wantLines)
+		}
+
+	})
+
+}
+
+func Test_runImport(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		args       []string
+		input      string
+		want       int
+		wantOutput string
+	}{
+		{"dry run", []string{"-dry-run"}, importedRequests, 1, "13 requests read: 4 would be imported, 9 failed\n"},
+		{"valid dry run", []string{"-dry-run", "-batch-size", "10", "-"}, strings.SplitN(importedRequests, "\n", 2)[0], 0, "1 requests read: 1 would be imported, 0 failed\n"},
+		{"missing file", []string{"-dry-run", "missing.jsonl"}, "", 1, ""},
+		{"invalid batch size", []string{"-batch-size", "0"}, "", 2, ""},
+		{"unknown flag", []string{"-force"}, "", 2, ""},
+		{"too many files", []string{"a.jsonl", "b.jsonl"}, "", 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var stdout, stderr bytes.Buffer
+			got := runImport(tt.args, strings.NewReader(tt.input), &stdout, &stderr)
+			if got != tt.want || stdout.String() != tt.wantOutput {
+				t.Errorf("runImport() = %d, %q, want %d, %q (stderr %q)", got, stdout.String(), tt.want, tt.wantOutput, stderr.String())
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package inline contains the functions to answer
+// inline queries.
+package inline
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+)
+
+const (
+	// maxLinks is the maximum number of links
+	// handled in a single inline query.
+	maxLinks = 5
+	// maxResultIDLength is the maximum length
+	// of the ID of an inline query result, in bytes.
+	maxResultIDLength = 64
+)
+
+// HandleInlineQuery answers an inline query, e.g. "@bot <amazon link>",
+// with an article containing the referral version of each link.
+// Short links are resolved with the Resolver of the dependencies
+// and the referral URLs are shortened with their Shortener.
+// The shortened URLs are reused from their Links, if not nil.
+// If the Products provider is set, the articles show the
+// title, price and image of the products. The requests are
+// saved by HandleChosenInlineResult, once the user sends one.
+func HandleInlineQuery(query *tgbotapi.InlineQuery, bot sender.Sender, deps services.Dependencies) (returnedURLs []string, err error) {
+
+	links := strings.Fields(query.Query)
+	if len(links) > maxLinks {
+		links = links[:maxLinks]
+	}
+
+	var referrals []urlwork.Referral
+	for _, result := range urlwork.GetReferrals(links, repository.ReferralIDs, deps.Resolver, deps.Shortener, deps.Links, repository.LinkWorkers, repository.LinkTimeout) {
+
+		if result.Err != nil {
+			log.Println(result.Err)
+			continue
+		}
+
+		returnedURLs = append(returnedURLs, result.Referral.URL)
+		referrals = append(referrals, result.Referral)
+
+	}
+
+	details := lookupProducts(referrals, deps.Products)
+	results := make([]interface{}, 0, len(referrals))
+	for i, referral := range referrals {
+		results = append(results, newArticle(newResultID(i, referral), referral, details[i]))
+	}
+
+	// The results are personal, as they
+	// are saved as the requests of the user.
+	_, err = bot.Request(tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       results,
+		CacheTime:     repository.InlineCacheTime,
+		IsPersonal:    true,
+	})
+
+	if err != nil {
+		err = errors.Errorf("HandleInlineQuery: unable to answer the query: %s", err)
+	}
+
+	return
+
+}
+
+// HandleChosenInlineResult saves the user and the request of an inline
+// query result the user has sent. Telegram only reports the chosen
+// results if the inline feedback is enabled in the BotFather.
+// The links that didn't fit in the result ID are read from the Links
+// of the dependencies, if not nil.
+func HandleChosenInlineResult(result *tgbotapi.ChosenInlineResult, deps services.Dependencies) error {
+
+	request, ok := parseResultID(result.ResultID, deps.Links)
+	if !ok {
+		return errors.Errorf("HandleChosenInlineResult: invalid result ID %q", result.ResultID)
+	}
+
+	err := persistence.PutUserRequests(deps.Store, result.From.ID, []structs.Request{request})
+	if err != nil {
+		return errors.Errorf("HandleChosenInlineResult: unable to save request: %s", err)
+	}
+
+	return nil
+
+}
+
+// newResultID returns the ID of the result of a referral link, made
+// of its position, the marketplace and the ASIN of the product and,
+// if it fits, the referral URL, so that the request can be saved
+// when the result is chosen. If the URL doesn't fit, the options of
+// the link are added after a question mark instead, if they fit.
+func newResultID(position int, referral urlwork.Referral) string {
+
+	id := strconv.Itoa(position) + " " + referral.Marketplace + " " + referral.ASIN
+	if len(id)+1+len(referral.URL) <= maxResultIDLength {
+		return id + " " + referral.URL
+	}
+
+	if referral.Options != "" && len(id)+2+len(referral.Options) <= maxResultIDLength {
+		return id + " ?" + referral.Options
+	}
+
+	return id
+
+}
+
+// parseResultID returns the request of a result ID created by
+// newResultID. The URLs that didn't fit in the ID are read from
+// the cache, if not nil, or replaced by the long referral link of
+// the product, with the options found in the ID.
+func parseResultID(id string, cache urlwork.LinkCache) (structs.Request, bool) {
+
+	fields := strings.Fields(id)
+	if len(fields) < 3 || len(fields) > 4 || !urlwork.IsASIN(fields[2]) {
+		return structs.Request{}, false
+	}
+
+	request := structs.Request{Marketplace: fields[1], ASIN: fields[2]}
+	if len(fields) == 4 && !strings.HasPrefix(fields[3], "?") {
+		request.URL = fields[3]
+		return request, true
+	}
+
+	tag, ok := repository.ReferralIDs[request.Marketplace]
+	if !ok {
+		return structs.Request{}, false
+	}
+
+	var options string
+	if len(fields) == 4 {
+		options = strings.TrimPrefix(fields[3], "?")
+	}
+
+	query, err := url.ParseQuery(options)
+	if err != nil {
+		return structs.Request{}, false
+	}
+
+	if cache != nil {
+
+		shortURL, found, err := cache.GetLink(request.Marketplace, request.ASIN, tag, options)
+		if err != nil {
+			log.Println("parseResultID: unable to read the cached link:", err)
+		}
+
+		if found {
+			request.URL = shortURL
+			return request, true
+		}
+
+	}
+
+	request.URL = urlwork.CanonicalURL(request.Marketplace, request.ASIN, tag, query)
+	return request, true
+
+}
+
+// lookupProducts returns the details of the products of the
+// referral links, in the same order. Telegram waits a few seconds
+// for the answer, so the lookup gets as long as a link conversion.
+// The products are left empty if there's no provider.
+func lookupProducts(referrals []urlwork.Referral, products metadata.Provider) []metadata.Product {
+
+	if products == nil {
+		return make([]metadata.Product, len(referrals))
+	}
+
+	items := make([]metadata.Item, len(referrals))
+	for i, referral := range referrals {
+		items[i] = metadata.Item{Marketplace: referral.Marketplace, ASIN: referral.ASIN, Tag: referral.Tag}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), repository.LinkTimeout)
+	defer cancel()
+
+	return metadata.LookupAll(ctx, products, items)
+
+}
+
+// newArticle returns the inline query result for a referral
+// link, with the details of the product, if they were found.
+func newArticle(id string, referral urlwork.Referral, product metadata.Product) tgbotapi.InlineQueryResultArticle {
+
+	article := tgbotapi.NewInlineQueryResultArticle(id, "Send the referral link", "➡️ "+referral.URL)
+	article.Description = referral.URL
+	article.URL = referral.URL
+	article.HideURL = true
+
+	if product.Title != "" {
+		article.Title = product.Title
+		article.InputMessageContent = tgbotapi.InputTextMessageContent{Text: product.Title + "\n➡️ " + referral.URL}
+	}
+
+	if product.Price != "" {
+		article.Description = product.Price + " · " + referral.URL
+	}
+
+	article.ThumbURL = product.ImageURL
+	return article
+
+}
@@ -0,0 +1,192 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package inline
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata/metadatatest"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender/sendertest"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork/urlworktest"
+)
+
+func TestHandleInlineQuery(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	resolver := urlworktest.NewResolver(map[string]string{"https://amzn.to/abc": "https://www.amazon.it/dp/B07PDHSPYD"})
+
+	tests := []struct {
+		name     string
+		query    string
+		wantURLs []string
+	}{
+		{"empty", "", nil},
+		{"no link", "hello there", nil},
+		{"product link", "https://www.amazon.it/gp/product/B00TEST123/", []string{"https://www.amazon.it/dp/B00TEST123?tag=ref-21"}},
+		{"short link", "amzn.to/abc", []string{"https://www.amazon.it/dp/B07PDHSPYD?tag=ref-21"}},
+		{"text and links", "look https://www.amazon.it/dp/B00TEST123 and https://amzn.to/abc", []string{
+			"https://www.amazon.it/dp/B00TEST123?tag=ref-21",
+			"https://www.amazon.it/dp/B07PDHSPYD?tag=ref-21",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			bot := &sendertest.Recorder{}
+			store := persistence.NewMemoryStore()
+			query := &tgbotapi.InlineQuery{ID: "42", From: &tgbotapi.User{ID: 7}, Query: tt.query}
+
+			gotURLs, err := HandleInlineQuery(query, bot, services.Dependencies{Store: store, Resolver: resolver, Shortener: urlwork.NoopShortener{}})
+			if err != nil {
+				t.Fatalf("HandleInlineQuery() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(gotURLs, tt.wantURLs) {
+				t.Errorf("HandleInlineQuery() = %v, want %v", gotURLs, tt.wantURLs)
+			}
+
+			// The query must always be answered, with an article per link.
+			if len(bot.Sent) != 1 {
+				t.Fatalf("HandleInlineQuery() sent %d requests, want 1", len(bot.Sent))
+			}
+
+			answer, ok := bot.Sent[0].(tgbotapi.InlineConfig)
+			if !ok {
+				t.Fatalf("HandleInlineQuery() sent %T, want tgbotapi.InlineConfig", bot.Sent[0])
+			}
+
+			if answer.InlineQueryID != query.ID || len(answer.Results) != len(tt.wantURLs) {
+				t.Errorf("HandleInlineQuery() answered %q with %d results, want %q with %d", answer.InlineQueryID, len(answer.Results), query.ID, len(tt.wantURLs))
+			}
+
+			// The requests are only saved once a result is chosen.
+			requests, _, err := store.GetRequestsPage(persistence.RequestQuery{}, "", 10)
+			if err != nil {
+				t.Fatalf("GetRequestsPage() error = %v", err)
+			}
+
+			if len(requests) != 0 {
+				t.Errorf("HandleInlineQuery() saved %d requests, want none", len(requests))
+			}
+
+		})
+	}
+
+}
+
+func Test_newArticle(t *testing.T) {
+
+	referral := urlwork.Referral{Marketplace: "amazon.it", ASIN: "B00TEST123", Tag: "ref-21", URL: "https://amzn.to/abc"}
+	products := metadatatest.Provider{
+		"B00TEST123": {ASIN: "B00TEST123", Title: "Headphones", Price: "€ 29,99", ImageURL: "https://m.media-amazon.com/test.jpg"},
+	}
+
+	tests := []struct {
+		name            string
+		products        metadata.Provider
+		asin            string
+		wantTitle       string
+		wantDescription string
+		wantThumbURL    string
+	}{
+		{"no provider", nil, "B00TEST123", "Send the referral link", "https://amzn.to/abc", ""},
+		{"unknown product", products, "B00TEST456", "Send the referral link", "https://amzn.to/abc", ""},
+		{"product", products, "B00TEST123", "Headphones", "€ 29,99 · https://amzn.to/abc", "https://m.media-amazon.com/test.jpg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			referral.ASIN = tt.asin
+			got := newArticle("0", referral, lookupProducts([]urlwork.Referral{referral}, tt.products)[0])
+			if got.Title != tt.wantTitle || got.Description != tt.wantDescription || got.ThumbURL != tt.wantThumbURL {
+				t.Errorf("newArticle() = %q, %q, %q, want %q, %q, %q", got.Title, got.Description, got.ThumbURL, tt.wantTitle, tt.wantDescription, tt.wantThumbURL)
+			}
+
+		})
+	}
+
+}
+
+func TestHandleChosenInlineResult(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	shortReferral := urlwork.Referral{Marketplace: "amazon.it", ASIN: "B00TEST123", Tag: "ref-21", URL: "https://amzn.to/abc"}
+	longReferral := urlwork.Referral{Marketplace: "amazon.it", ASIN: "B00TEST123", Tag: "ref-21", Options: "psc=1&smid=A11IL2PNWYJU7H", URL: "https://www.amazon.it/dp/B00TEST123?psc=1&smid=A11IL2PNWYJU7H&tag=ref-21"}
+	longOptions := longReferral
+	longOptions.Options = "smid=" + strings.Repeat("A", 40)
+
+	tests := []struct {
+		name     string
+		resultID string
+		cached   string
+		wantURL  string
+		wantErr  bool
+	}{
+		{"short link", newResultID(0, shortReferral), "", "https://amzn.to/abc", false},
+		{"link too long for the ID", newResultID(1, longReferral), "", "https://www.amazon.it/dp/B00TEST123?psc=1&smid=A11IL2PNWYJU7H&tag=ref-21", false},
+		{"link shortened later", newResultID(1, longReferral), "https://amzn.to/def", "https://amzn.to/def", false},
+		{"options too long for the ID", newResultID(1, longOptions), "", "https://www.amazon.it/dp/B00TEST123?tag=ref-21", false},
+		{"unsupported marketplace", "0 amazon.de B00TEST123", "", "", true},
+		{"invalid options", "0 amazon.it B00TEST123 ?%zz", "", "", true},
+		{"invalid ID", "0", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if len(tt.resultID) > maxResultIDLength {
+				t.Fatalf("newResultID() = %q, longer than %d bytes", tt.resultID, maxResultIDLength)
+			}
+
+			store := persistence.NewMemoryStore()
+			links := persistence.NewLinkCache(store, 10, time.Hour)
+			if tt.cached != "" {
+				if err := links.PutLink("amazon.it", "B00TEST123", "ref-21", longReferral.Options, tt.cached); err != nil {
+					t.Fatalf("PutLink() error = %v", err)
+				}
+			}
+
+			result := &tgbotapi.ChosenInlineResult{ResultID: tt.resultID, From: &tgbotapi.User{ID: 7}}
+			err := HandleChosenInlineResult(result, services.Dependencies{Store: store, Links: links})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HandleChosenInlineResult() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			requests, _, err := store.GetRequestsPage(persistence.RequestQuery{}, "", 10)
+			if err != nil {
+				t.Fatalf("GetRequestsPage() error = %v", err)
+			}
+
+			if tt.wantErr {
+				if len(requests) != 0 {
+					t.Errorf("HandleChosenInlineResult() saved %v, want no requests", requests)
+				}
+				return
+			}
+
+			if len(requests) != 1 || requests[0].URL != tt.wantURL || requests[0].ASIN != "B00TEST123" || requests[0].Marketplace != "amazon.it" {
+				t.Errorf("HandleChosenInlineResult() saved %+v, want a request for %s", requests, tt.wantURL)
+			}
+
+		})
+	}
+
+}
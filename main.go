@@ -6,81 +6,284 @@ package main
 
 import (
 	"log"
+	"os"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/commands"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/inline"
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/messages"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
 )
 
 // main is the "entrance" to the program and the function that
 // must be registered in the lambda function's page in the
-// "handler" field.
+// "handler" field. When called with the import argument,
+// it imports the requests of a JSONL file instead.
 func main() {
 
+	// The import command only needs the storage configuration.
+	if len(os.Args) > 1 && os.Args[1] == importCommand {
+		os.Exit(runImport(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
+
 	// If any of the environment variables are missing,
 	// the application will exit.
 	repository.LoadEnvVariables()
 
-	// The AWS Session creation may fail.
-	// In this case, we will just try to handle the message.
+	store := newStore()
+	putAdmins(store, repository.AdminIDs)
+	deps := services.Dependencies{
+		Store:     store,
+		Resolver:  newResolver(),
+		Shortener: newShortener(),
+		Links:     newLinkCache(store),
+		Products:  newProducts(),
+	}
+
+	bot, err := tgbotapi.NewBotAPI(repository.TelegramBotToken)
+	if err != nil {
+		log.Fatalf("main: unable to create the bot instance: %s", err)
+	}
+
+	switch repository.RunMode {
+	case repository.RunModePolling:
+		runPolling(bot, deps)
+	case repository.RunModeWebhook:
+		runWebhook(bot, deps)
+	default:
+		startLambda(bot, deps)
+	}
+
+}
+
+// startLambda starts the Lambda handler for the configured event.
+func startLambda(bot *tgbotapi.BotAPI, deps services.Dependencies) {
+
+	switch repository.LambdaEvent {
+	case repository.LambdaEventProxy:
+		lambda.Start(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+			return HandleAPIGatewayRequest(request, bot, deps), nil
+		})
+	case repository.LambdaEventHTTP:
+		lambda.Start(func(request events.APIGatewayV2HTTPRequest) (events.APIGatewayV2HTTPResponse, error) {
+			return HandleHTTPAPIRequest(request, bot, deps), nil
+		})
+	default:
+		lambda.Start(func(update tgbotapi.Update) {
+			// If the Lambda Proxy integration is active, the update
+			// won't be correctly deserialized and will be empty.
+			if update.UpdateID == 0 {
+				log.Printf("main: received an empty update. If the Lambda Proxy integration is active, set LAMBDA_EVENT to %s", repository.LambdaEventProxy)
+			}
+			HandleUpdate(update, bot, deps)
+		})
+	}
+
+}
+
+// newStore returns the Store used to persist users and requests,
+// according to the configured storage backend.
+// The AWS Session creation may fail: in this case, we will fall
+// back to an in-memory store and just try to handle the messages.
+func newStore() persistence.Store {
+
+	switch repository.StorageBackend {
+	case repository.StorageMemory:
+		return persistence.NewMemoryStore()
+	case repository.StorageBolt:
+		store, err := persistence.NewBoltStore(repository.BoltDBPath)
+		if err != nil {
+			log.Fatalf("newStore: %s", err)
+		}
+		return store
+	}
+
 	err := repository.CreateAWSSession()
 	if err != nil {
-		log.Println("main: unable to create AWS session:", err)
-	} else {
-		repository.StartDynamoDBClient()
+		log.Println("newStore: unable to create AWS session, using in-memory storage:", err)
+		return persistence.NewMemoryStore()
+	}
+
+	// The replies are not saved without their table,
+	// so that the edited messages get a new reply.
+	if (structs.Reply{}).Table() == "" {
+		log.Println("newStore: REPLY_TABLE_NAME is not set, the replies to edited messages won't be edited")
+	}
+
+	return persistence.NewDynamoDBStore(dynamodb.New(repository.AWSSession))
+
+}
+
+// putAdmins marks the users as admins, so that they
+// can use the admin commands with any storage backend.
+func putAdmins(store persistence.Store, adminIDs []int) {
+
+	for _, adminID := range adminIDs {
+		if err := store.PutAdmin(adminID); err != nil {
+			log.Printf("putAdmins: unable to make %d an admin: %s", adminID, err)
+		}
+	}
+
+}
+
+// newResolver returns the Resolver used for the short links,
+// allowed to contact the default short link hosts, the
+// configured ones and the custom Bitly domain, if any.
+func newResolver() urlwork.Resolver {
+
+	hosts := append([]string{}, urlwork.DefaultShortLinkHosts...)
+	hosts = append(hosts, repository.ShortLinkHosts...)
+	if repository.BitlyDomain != "" {
+		hosts = append(hosts, repository.BitlyDomain)
+	}
+
+	return urlwork.NewHTTPResolver(hosts)
+
+}
+
+// newShortener returns the Shortener used for
+// the referral links, according to the configuration.
+// The shortenings are retried if the service is unavailable.
+func newShortener() urlwork.Shortener {
+
+	var shortener urlwork.Shortener
+	switch repository.Shortener {
+	case repository.ShortenerNone:
+		return urlwork.NoopShortener{}
+	case repository.ShortenerYOURLS:
+		shortener = urlwork.NewYOURLSShortener(repository.ShortenerURL, repository.ShortenerKey)
+	case repository.ShortenerKutt:
+		shortener = urlwork.NewKuttShortener(repository.ShortenerURL, repository.ShortenerKey)
+	default:
+		shortener = urlwork.NewBitlyShortener(repository.BitlyAPIKey, repository.BitlyDomain, repository.BitlyGroupGUID)
+	}
+
+	return urlwork.NewRetryShortener(shortener, repository.ShortenerRetries, urlwork.DefaultShortenerBackoff)
+
+}
+
+// newLinkCache returns the cache of the shortened referral links,
+// or nil if the links are not shortened or the caching is disabled.
+// The caching is also disabled if DynamoDB has no table for the links.
+func newLinkCache(store persistence.Store) urlwork.LinkCache {
+
+	if repository.Shortener == repository.ShortenerNone || repository.LinkCacheTTL == 0 {
+		return nil
+	}
+
+	if repository.StorageBackend == repository.StorageDynamoDB && (structs.Link{}).Table() == "" {
+		log.Println("newLinkCache: LINK_TABLE_NAME is not set, the links won't be cached")
+		return nil
+	}
+
+	return persistence.NewLinkCache(store, repository.LinkCacheSize, repository.LinkCacheTTL)
+
+}
+
+// newProducts returns the Provider of the details of
+// the products, or nil if it's not configured.
+func newProducts() metadata.Provider {
+
+	if repository.PAAPIAccessKey == "" {
+		return nil
 	}
 
-	lambda.Start(HandleUpdate)
+	return metadata.NewPAAPIProvider(repository.PAAPIAccessKey, repository.PAAPISecretKey)
 
 }
 
-//HandleUpdate handles a Telegram Update.
-func HandleUpdate(update tgbotapi.Update) {
+// HandleUpdate handles a Telegram Update.
+func HandleUpdate(update tgbotapi.Update, bot sender.Sender, deps services.Dependencies) {
 
-	// In some cases, like if the Lambda Proxy is active,
-	// the message may not be correctly deserialized, ending
-	// with a nil pointer dereference down the execution.
+	if update.InlineQuery != nil {
+		_, err := inline.HandleInlineQuery(update.InlineQuery, bot, deps)
+		if err != nil {
+			log.Println("HandleUpdate: error while handling inline query:", err)
+		}
+		return
+	}
+
+	if update.ChosenInlineResult != nil {
+		err := inline.HandleChosenInlineResult(update.ChosenInlineResult, deps)
+		if err != nil {
+			log.Println("HandleUpdate: error while handling chosen inline result:", err)
+		}
+		return
+	}
+
+	if update.CallbackQuery != nil {
+		commands.HandleCallbackQuery(update.CallbackQuery, bot, deps.Store)
+		return
+	}
+
+	// Edited messages and channel posts may contain new links,
+	// while edited commands have already been answered.
+	if edited := editedMessage(update); edited != nil {
+		if edited.IsCommand() {
+			return
+		}
+
+		_, err := messages.HandleEditedMessage(edited, bot, deps)
+		if err != nil {
+			log.Println("HandleUpdate: error while handling edited message:", err)
+		}
+		return
+	}
+
+	// Posts in the channels the bot manages are handled like
+	// messages, ignore the other updates without a message.
 	msg := update.Message
 	if msg == nil {
-		log.Fatal("HandleUpdate: the message was nil. Make sure the Lambda Proxy integration is not active")
+		msg = update.ChannelPost
 	}
 
-	bot, err := tgbotapi.NewBotAPI(repository.TelegramBotToken)
-	if err != nil {
-		log.Fatalf("HandleUpdate: unable to create the bot instance: %s", err)
+	if msg == nil {
+		log.Println("HandleUpdate: ignoring update without a message:", update.UpdateID)
+		return
 	}
 
-	_, _ = bot.Send(tgbotapi.NewChatAction(msg.Chat.ID, "typing"))
+	// Don't flood groups and channels with chat actions.
+	if msg.Chat.IsPrivate() {
+		_, _ = bot.Send(tgbotapi.NewChatAction(msg.Chat.ID, "typing"))
+	}
 
 	// We don't want to record users that just use the commands
-	// in our DynamoDB instance, so we will return after handling
-	// the command.
+	// in our store, so we will return after handling the command.
+	// Channel posts have no sender, so they can't run commands.
 	if msg.IsCommand() {
-		commands.HandleCommand(msg, bot)
-		return
-	}
+		if msg.From == nil {
+			return
+		}
 
-	urls, err := messages.HandleMessage(msg, bot)
-	if err != nil || repository.DynamoDBClient == nil {
-		log.Println("HandleUpdate: error while handling message or nil DynamoDB client:", err, repository.DynamoDBClient)
+		commands.HandleCommand(msg, bot, deps.Store)
 		return
 	}
 
-	//Persist results
-	err = persistence.PutUser(msg.From.ID, repository.DynamoDBClient)
+	_, err := messages.HandleMessage(msg, bot, deps)
 	if err != nil {
-		log.Println(err)
+		log.Println("HandleUpdate: error while handling message:", err)
 	}
 
-	for _, uri := range urls {
-		err = persistence.PutRequest(msg.From.ID, uri, repository.DynamoDBClient)
-		if err != nil {
-			log.Println(err)
-		}
+}
+
+// editedMessage returns the edited message or
+// channel post of an update, if any.
+func editedMessage(update tgbotapi.Update) *tgbotapi.Message {
+
+	if update.EditedMessage != nil {
+		return update.EditedMessage
 	}
 
+	return update.EditedChannelPost
+
 }
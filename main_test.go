@@ -0,0 +1,24 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+)
+
+func Test_putAdmins(t *testing.T) {
+
+	store := persistence.NewMemoryStore()
+	putAdmins(store, []int{1, 2})
+
+	for userID, want := range map[int]bool{1: true, 2: true, 3: false} {
+		if isAdmin, err := store.IsUserAdmin(userID); err != nil || isAdmin != want {
+			t.Errorf("IsUserAdmin(%d) = %v, %v, want %v, nil", userID, isAdmin, err, want)
+		}
+	}
+
+}
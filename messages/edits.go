@@ -0,0 +1,199 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"context"
+	"log"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/utility"
+)
+
+// HandleEditedMessage handles edited messages and returns referral URLs.
+// If the bot already replied to the message, the reply is edited with
+// the new referral URLs and only the URLs that weren't in it are saved
+// in the store. Otherwise, the message is handled as a new one.
+// Photos of the products can't be edited into text messages,
+// so they are deleted and replaced by a new reply.
+func HandleEditedMessage(msg *tgbotapi.Message, bot sender.Sender, deps services.Dependencies) (returnedURLs []string, err error) {
+
+	store := deps.Store
+	previous, found, err := store.GetReply(msg.Chat.ID, msg.MessageID)
+	if err != nil {
+		log.Println("HandleEditedMessage: unable to retrieve the previous reply:", err)
+	}
+
+	if !found {
+		return HandleMessage(msg, bot, deps)
+	}
+
+	text := utility.GetMessageText(msg)
+	entities := utility.GetMessageEntities(msg)
+	tUTF16 := utf16.Encode([]rune(text))
+	urls := GetURLs(tUTF16, entities)
+	results := convertLinks(urls, deps)
+	returnedURLs, refURLs := referralURLs(results)
+
+	// Telegram refuses edits that don't change the message. When the
+	// reply contains the text of the message, it must be edited anyway.
+	if equalURLs(previous.URLs, returnedURLs) && (len(returnedURLs) == 0 || repository.ReplyMode != repository.ReplyModeRewrite) {
+		return
+	}
+
+	if !msg.Chat.IsPrivate() {
+		results = successfulResults(results)
+	}
+
+	switch {
+	case len(returnedURLs) > 0:
+		ctx, cancel := context.WithTimeout(context.Background(), repository.LinkTimeout)
+		details := lookupProducts(ctx, results, deps.Products)
+		cancel()
+		reply, parseMode := referralReply(tUTF16, entities, results, refURLs, details)
+		if previous.IsPhoto {
+			replacePhotoReply(msg, previous, results, details, reply, parseMode, returnedURLs, bot, store)
+		} else {
+			editReply(msg, previous, reply, parseMode, returnedURLs, bot, store)
+		}
+	case msg.Chat.IsPrivate() && len(urls) == 0:
+		editReply(msg, previous, "No URLs found 😢", "", nil, bot, store)
+	case msg.Chat.IsPrivate():
+		editReply(msg, previous, replyText(results), "", nil, bot, store)
+	default:
+		// In groups and channels, the links were removed:
+		// the previous reply is not needed anymore.
+		deleteReply(previous, bot, store)
+	}
+
+	// Only save the requests the previous reply didn't contain.
+	newResults := make([]urlwork.ReferralResult, 0, len(results))
+	for _, result := range results {
+		if result.Err == nil && !containsURL(previous.URLs, result.Referral.URL) {
+			newResults = append(newResults, result)
+		}
+	}
+
+	if msg.From != nil && len(newResults) > 0 {
+		persistRequests(msg.From.ID, newResults, store)
+	}
+
+	return
+
+}
+
+// editReply replaces the text of the previous reply to a message.
+// If the reply can't be edited, e.g. because it was deleted, a new
+// one is sent. Photo replies are replaced with a text one.
+func editReply(msg *tgbotapi.Message, previous structs.Reply, text string, parseMode string, returnedURLs []string, bot sender.Sender, store persistence.Store) {
+
+	if previous.IsPhoto {
+		replacePhotoReply(msg, previous, nil, nil, text, parseMode, returnedURLs, bot, store)
+		return
+	}
+
+	edit := tgbotapi.NewEditMessageText(previous.ChatID, previous.ReplyID, text)
+	edit.ParseMode = parseMode
+
+	_, err := bot.Send(edit)
+	if err != nil && !isNotModified(err) {
+		log.Println("editReply: unable to edit reply, sending a new one:", err)
+		sendReply(msg, text, parseMode, returnedURLs, bot, store)
+		return
+	}
+
+	previous.URLs = returnedURLs
+	err = store.PutReply(previous)
+	if err != nil {
+		log.Println("editReply: unable to save reply:", err)
+	}
+
+}
+
+// replacePhotoReply deletes the previous reply to a message, a photo
+// of the product, and sends a new one: a photo, if there's a single
+// product with an image, or the text otherwise.
+func replacePhotoReply(msg *tgbotapi.Message, previous structs.Reply, results []urlwork.ReferralResult, details []metadata.Product, text string, parseMode string, returnedURLs []string, bot sender.Sender, store persistence.Store) {
+
+	_, err := bot.Request(tgbotapi.NewDeleteMessage(previous.ChatID, previous.ReplyID))
+	if err != nil {
+		log.Println("replacePhotoReply: unable to delete reply:", err)
+	}
+
+	if !sendProductPhoto(msg, results, details, bot, store) {
+		sendReply(msg, text, parseMode, returnedURLs, bot, store)
+	}
+
+}
+
+// isNotModified returns true if Telegram refused an edit because it
+// doesn't change the message. The errors of the Telegram API only
+// carry the description, that starts with the 400 status text.
+func isNotModified(err error) bool {
+
+	apiErr, ok := errors.Cause(err).(tgbotapi.Error)
+	return ok && strings.HasPrefix(apiErr.Message, "Bad Request: message is not modified")
+
+}
+
+// deleteReply deletes the previous reply to a message.
+// The reply is kept in the store without URLs, so that
+// the URLs added by later edits are saved again.
+func deleteReply(previous structs.Reply, bot sender.Sender, store persistence.Store) {
+
+	_, err := bot.Request(tgbotapi.NewDeleteMessage(previous.ChatID, previous.ReplyID))
+	if err != nil {
+		log.Println("deleteReply: unable to delete reply:", err)
+	}
+
+	previous.URLs = nil
+	err = store.PutReply(previous)
+	if err != nil {
+		log.Println("deleteReply: unable to save reply:", err)
+	}
+
+}
+
+// equalURLs returns true if the two lists
+// contain the same URLs in the same order.
+func equalURLs(a []string, b []string) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+
+}
+
+// containsURL returns true if the list contains the URL.
+func containsURL(urls []string, uri string) bool {
+
+	for _, u := range urls {
+		if u == uri {
+			return true
+		}
+	}
+
+	return false
+
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"strings"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+)
+
+// explainError returns a human explanation of the
+// reason a referral link couldn't be generated.
+func explainError(err error) string {
+
+	switch urlwork.KindOf(err) {
+	case urlwork.KindInvalidURL:
+		return "This is not a valid link 🤔"
+	case urlwork.KindNotAmazon:
+		return "This is not an Amazon link 🤷"
+	case urlwork.KindUnsupportedMarketplace:
+		return "This Amazon marketplace is not supported. Supported marketplaces: " + strings.Join(urlwork.SupportedMarketplaces(repository.ReferralIDs), ", ")
+	case urlwork.KindNotAProduct:
+		return "This link doesn't point to a product 🔍"
+	case urlwork.KindResolverFailure:
+		return "I couldn't open this short link, try sending the full one"
+	case urlwork.KindShortenerFailure:
+		return "I couldn't shorten the referral link, try again later"
+	case urlwork.KindTimeout:
+		return "It took too long, try again later ⏳"
+	default:
+		return "Something went wrong 😫"
+	}
+
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"html"
+	"log"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+)
+
+// repostMessage sends again a message sent in a group or channel,
+// replacing its links with the referral ones, and deletes the
+// original one. It returns false if the message couldn't be
+// reposted, so that the caller replies to it instead: media
+// messages are never reposted, as only their caption would be
+// sent again, and the repost is deleted if the original one
+// can't be, e.g. because the bot is not an admin of the chat.
+func repostMessage(msg *tgbotapi.Message, tUTF16 []uint16, entities []tgbotapi.MessageEntity, refURLs map[string]string, bot sender.Sender) bool {
+
+	// The links of media messages are in their caption.
+	if msg.Text == "" {
+		return false
+	}
+
+	text := rewrittenHTML(tUTF16, entities, refURLs)
+	if author := authorName(msg); author != "" {
+		text = html.EscapeString(author) + ":\n" + text
+	}
+
+	repost := tgbotapi.NewMessage(msg.Chat.ID, text)
+	repost.ParseMode = "HTML"
+	if msg.ReplyToMessage != nil {
+		repost.ReplyToMessageID = msg.ReplyToMessage.MessageID
+	}
+
+	sent, err := bot.Send(repost)
+	if err != nil {
+		log.Println("repostMessage: unable to repost message, replying instead:", err)
+		return false
+	}
+
+	_, err = bot.Request(tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID))
+	if err != nil {
+		log.Println("repostMessage: unable to delete message, replying instead:", err)
+		_, _ = bot.Request(tgbotapi.NewDeleteMessage(msg.Chat.ID, sent.MessageID))
+		return false
+	}
+
+	return true
+
+}
+
+// authorName returns the name of the author of a message,
+// i.e. its sender or the signature of a channel post.
+func authorName(msg *tgbotapi.Message) string {
+
+	if msg.From == nil {
+		return msg.AuthorSignature
+	}
+
+	name := strings.TrimSpace(msg.From.FirstName + " " + msg.From.LastName)
+	if name == "" {
+		return msg.From.String()
+	}
+
+	return name
+
+}
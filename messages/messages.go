@@ -7,6 +7,7 @@
 package messages
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"strings"
@@ -14,57 +15,241 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pkg/errors"
-	"github.com/retgits/bitly/client"
 
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/utility"
 )
 
 // HandleMessage handles messages and returns referral URLs.
-func HandleMessage(msg *tgbotapi.Message, bot *tgbotapi.BotAPI) (returnedURLs []string, err error) {
+// Short links are resolved with the Resolver of the dependencies
+// and the referral URLs are shortened with their Shortener.
+// The shortened URLs are reused from their Links, if not nil.
+// The user and the generated URLs are saved in their Store.
+// In groups and channels, messages without Amazon links are
+// silently ignored and the referral URLs are sent as a reply
+// to the original message, or in its place if GroupRepost is set.
+// If the Products provider is set, the list of the referral URLs
+// contains the details of the products, with a photo if possible.
+func HandleMessage(msg *tgbotapi.Message, bot sender.Sender, deps services.Dependencies) (returnedURLs []string, err error) {
 
+	store := deps.Store
 	text := utility.GetMessageText(msg)
 	entities := utility.GetMessageEntities(msg)
 	tUTF16 := utf16.Encode([]rune(text))
 	urls := GetURLs(tUTF16, entities)
+	isPrivate := msg.Chat.IsPrivate()
 
 	if len(urls) == 0 {
-		_, _ = bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "No URLs found 😢"))
+		if !isPrivate {
+			return
+		}
+		sendReply(msg, "No URLs found 😢", "", nil, bot, store)
 		err = errors.Errorf("No URLs found.\nText was: %s", text)
 		return
 	}
 
-	// Generate a new Bitly client
-	bitlyClient := client.NewClient().WithAccessToken(repository.BitlyAPIKey)
-	for _, url := range urls {
+	results := convertLinks(urls, deps)
+	returnedURLs, refURLs := referralURLs(results)
 
-		refurl, err := urlwork.GetRefURL(url, repository.ReferralID, bitlyClient)
-		if err != nil {
-			log.Println(err)
-			continue
+	if len(returnedURLs) == 0 {
+		if !isPrivate {
+			return
 		}
+		sendReply(msg, replyText(results), "", nil, bot, store)
+		err = errors.Errorf("No matching URLs found.\nText was: %s", text)
+		return
+	}
 
-		returnedURLs = append(returnedURLs, refurl)
+	// The links that can't be converted are
+	// only explained in private chats.
+	if !isPrivate {
+		results = successfulResults(results)
+	}
 
+	if isPrivate || !repository.GroupRepost || !repostMessage(msg, tUTF16, entities, refURLs, bot) {
+		// The products are looked up within the time
+		// allowed to convert a link, all at once.
+		ctx, cancel := context.WithTimeout(context.Background(), repository.LinkTimeout)
+		details := lookupProducts(ctx, results, deps.Products)
+		cancel()
+		if !sendProductPhoto(msg, results, details, bot, store) {
+			text, parseMode := referralReply(tUTF16, entities, results, refURLs, details)
+			sendReply(msg, text, parseMode, returnedURLs, bot, store)
+		}
 	}
 
-	if len(returnedURLs) == 0 {
-		_, _ = bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "No matching URLs found 😢"))
-		err = errors.Errorf("No matching URLs found.\nText was: %s", text)
-		return
+	// Channel posts have no sender to save the requests for.
+	if msg.From != nil {
+		persistRequests(msg.From.ID, results, store)
 	}
 
-	builder := strings.Builder{}
-	for _, uri := range returnedURLs {
-		builder.WriteString(fmt.Sprintf("➡️ %s\n\n", uri))
+	return
+
+}
+
+// convertLinks converts the URLs concurrently,
+// returning the results in the same order.
+func convertLinks(urls []string, deps services.Dependencies) []urlwork.ReferralResult {
+
+	results := urlwork.GetReferrals(urls, repository.ReferralIDs, deps.Resolver, deps.Shortener, deps.Links, repository.LinkWorkers, repository.LinkTimeout)
+	for _, result := range results {
+		if result.Err != nil {
+			log.Println(result.Err)
+		}
+	}
+
+	return results
+
+}
+
+// referralURLs returns the referral URLs of the links that
+// were converted and a map from each link to its referral URL.
+func referralURLs(results []urlwork.ReferralResult) (returnedURLs []string, refURLs map[string]string) {
+
+	refURLs = make(map[string]string, len(results))
+	for _, result := range results {
+		if result.Err == nil {
+			refURLs[result.Link] = result.Referral.URL
+			returnedURLs = append(returnedURLs, result.Referral.URL)
+		}
 	}
-	_, _ = bot.Send(tgbotapi.NewMessage(msg.Chat.ID, builder.String()))
 
 	return
 
 }
 
+// successfulResults returns the results of the
+// links that were converted, in the same order.
+func successfulResults(results []urlwork.ReferralResult) []urlwork.ReferralResult {
+
+	successful := make([]urlwork.ReferralResult, 0, len(results))
+	for _, result := range results {
+		if result.Err == nil {
+			successful = append(successful, result)
+		}
+	}
+
+	return successful
+
+}
+
+// referralReply returns the text of the reply with the referral URLs
+// and its parse mode. With ReplyModeRewrite, it's the text of the
+// message, with its links replaced and its formatting preserved,
+// followed by the explanations of the links that failed.
+// Otherwise, it's the list of the results, with the details
+// of the products, if any.
+func referralReply(tUTF16 []uint16, entities []tgbotapi.MessageEntity, results []urlwork.ReferralResult, refURLs map[string]string, details []metadata.Product) (text string, parseMode string) {
+
+	if repository.ReplyMode == repository.ReplyModeRewrite {
+		text = rewrittenHTML(tUTF16, entities, refURLs)
+		if failures := failuresHTML(results); failures != "" {
+			text += "\n\n" + failures
+		}
+		return text, "HTML"
+	}
+
+	if details != nil {
+		return productsText(results, details), "HTML"
+	}
+
+	return replyText(results), ""
+
+}
+
+// replyText returns the list of the referral URLs,
+// explaining why the other links couldn't be converted.
+func replyText(results []urlwork.ReferralResult) string {
+
+	builder := strings.Builder{}
+	for _, result := range results {
+
+		if result.Err != nil {
+			builder.WriteString(fmt.Sprintf("❌ %s\n%s\n\n", result.Link, explainError(result.Err)))
+			continue
+		}
+
+		builder.WriteString(fmt.Sprintf("➡️ %s\n\n", result.Referral.URL))
+
+	}
+
+	return builder.String()
+
+}
+
+// sendReply sends the reply to a message and saves it, with
+// the referral URLs it contains, so that it can be edited
+// if the message is edited. In groups and channels, the
+// reply refers to the original message.
+func sendReply(msg *tgbotapi.Message, text string, parseMode string, returnedURLs []string, bot sender.Sender, store persistence.Store) {
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ParseMode = parseMode
+	if !msg.Chat.IsPrivate() {
+		reply.ReplyToMessageID = msg.MessageID
+	}
+
+	err := sendAndSave(msg, reply, returnedURLs, bot, store)
+	if err != nil {
+		log.Println("sendReply: unable to send reply:", err)
+	}
+
+}
+
+// sendAndSave sends the reply to a message and saves it,
+// with the referral URLs it contains. Errors while saving
+// the reply are only logged.
+func sendAndSave(msg *tgbotapi.Message, reply tgbotapi.Chattable, returnedURLs []string, bot sender.Sender, store persistence.Store) error {
+
+	sent, err := bot.Send(reply)
+	if err != nil {
+		return err
+	}
+
+	// Replies held back for the webhook response have no ID.
+	if sent.MessageID == 0 {
+		return nil
+	}
+
+	_, isPhoto := reply.(tgbotapi.PhotoConfig)
+	err = store.PutReply(structs.Reply{ChatID: msg.Chat.ID, MessageID: msg.MessageID, ReplyID: sent.MessageID, URLs: returnedURLs, IsPhoto: isPhoto})
+	if err != nil {
+		log.Println("sendAndSave: unable to save reply:", err)
+	}
+
+	return nil
+
+}
+
+// persistRequests saves the user and the referral URLs they
+// requested, skipping the links that couldn't be converted.
+// Errors are only logged, as the user already received the reply.
+func persistRequests(userID int, results []urlwork.ReferralResult, store persistence.Store) {
+
+	requests := make([]structs.Request, 0, len(results))
+	for _, result := range results {
+		if result.Err == nil {
+			requests = append(requests, structs.Request{
+				URL:         result.Referral.URL,
+				Marketplace: result.Referral.Marketplace,
+				ASIN:        result.Referral.ASIN,
+			})
+		}
+	}
+
+	err := persistence.PutUserRequests(store, userID, requests)
+	if err != nil {
+		log.Println("persistRequests: unable to save requests:", err)
+	}
+
+}
+
 // GetURLs returns the urls and the markdown links in a message.
 func GetURLs(tUTF16 []uint16, entities []tgbotapi.MessageEntity) (urls []string) {
 
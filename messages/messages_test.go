@@ -0,0 +1,608 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata/metadatatest"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender/sendertest"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork/urlworktest"
+)
+
+// noShortLinks doesn't resolve any link, like the
+// HTTPResolver does with the hosts that are not allowed.
+var noShortLinks = &urlworktest.Resolver{NotFound: urlwork.ErrHostNotAllowed}
+
+// newDependencies returns the dependencies of the handlers,
+// that don't resolve the short links nor shorten the referral ones.
+func newDependencies(store persistence.Store, products metadata.Provider) services.Dependencies {
+	return services.Dependencies{Store: store, Resolver: noShortLinks, Shortener: urlwork.NoopShortener{}, Products: products}
+}
+
+// failPhotos returns the SendErr of a sendertest.Recorder
+// that fails to send the photos, if fail is true.
+func failPhotos(fail bool) func(c tgbotapi.Chattable) error {
+
+	return func(c tgbotapi.Chattable) error {
+		if _, isPhoto := c.(tgbotapi.PhotoConfig); isPhoto && fail {
+			return errors.New("Bad Request: wrong file identifier/HTTP URL specified")
+		}
+		return nil
+	}
+
+}
+
+func TestRewriteLinks(t *testing.T) {
+
+	refURLs := map[string]string{
+		"amzn.to/abc":                         "https://www.amazon.it/dp/B07PDHSPYD?tag=ref-21",
+		"https://www.amazon.it/dp/B00TEST123": "https://www.amazon.it/dp/B00TEST123?tag=ref-21",
+	}
+
+	tests := []struct {
+		name         string
+		text         string
+		entities     []tgbotapi.MessageEntity
+		wantText     string
+		wantEntities []tgbotapi.MessageEntity
+	}{
+		{"no entities", "hello", nil, "hello", []tgbotapi.MessageEntity{}},
+		{
+			"url between formatting",
+			"😍 look amzn.to/abc now!",
+			[]tgbotapi.MessageEntity{
+				{Type: "bold", Offset: 0, Length: 7},
+				{Type: "url", Offset: 8, Length: 11},
+				{Type: "italic", Offset: 20, Length: 3},
+			},
+			"😍 look https://www.amazon.it/dp/B07PDHSPYD?tag=ref-21 now!",
+			[]tgbotapi.MessageEntity{
+				{Type: "bold", Offset: 0, Length: 7},
+				{Type: "url", Offset: 8, Length: 46},
+				{Type: "italic", Offset: 55, Length: 3},
+			},
+		},
+		{
+			"formatting around url",
+			"Deal: amzn.to/abc",
+			[]tgbotapi.MessageEntity{
+				{Type: "italic", Offset: 0, Length: 17},
+				{Type: "url", Offset: 6, Length: 11},
+				{Type: "bold", Offset: 8, Length: 2},
+			},
+			"Deal: https://www.amazon.it/dp/B07PDHSPYD?tag=ref-21",
+			[]tgbotapi.MessageEntity{
+				{Type: "italic", Offset: 0, Length: 52},
+				{Type: "url", Offset: 6, Length: 46},
+				{Type: "bold", Offset: 6, Length: 46},
+			},
+		},
+		{
+			"text link and unknown url",
+			"this and example.com",
+			[]tgbotapi.MessageEntity{
+				{Type: "text_link", Offset: 0, Length: 4, URL: "https://www.amazon.it/dp/B00TEST123"},
+				{Type: "url", Offset: 9, Length: 11},
+			},
+			"this and example.com",
+			[]tgbotapi.MessageEntity{
+				{Type: "text_link", Offset: 0, Length: 4, URL: "https://www.amazon.it/dp/B00TEST123?tag=ref-21"},
+				{Type: "url", Offset: 9, Length: 11},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			gotUTF16, gotEntities := RewriteLinks(utf16.Encode([]rune(tt.text)), tt.entities, refURLs)
+			if got := string(utf16.Decode(gotUTF16)); got != tt.wantText {
+				t.Errorf("RewriteLinks() text = %q, want %q", got, tt.wantText)
+			}
+
+			if !reflect.DeepEqual(gotEntities, tt.wantEntities) {
+				t.Errorf("RewriteLinks() entities = %+v, want %+v", gotEntities, tt.wantEntities)
+			}
+
+		})
+	}
+
+}
+
+func TestHandleMessage_rewrite(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	repository.ReplyMode = repository.ReplyModeRewrite
+	defer func() {
+		repository.ReferralIDs = nil
+		repository.ReplyMode = ""
+	}()
+
+	entities := []tgbotapi.MessageEntity{
+		{Type: "bold", Offset: 0, Length: 10},
+		{Type: "text_link", Offset: 12, Length: 10, URL: "https://www.amazon.it/dp/B00TEST123"},
+		{Type: "url", Offset: 26, Length: 35},
+	}
+
+	const (
+		text      = "Only today: headphones <3 https://www.amazon.fr/dp/B00TEST456"
+		rewritten = `<b>Only today</b>: <a href="https://www.amazon.it/dp/B00TEST123?tag=ref-21">headphones</a> &lt;3 https://www.amazon.fr/dp/B00TEST456`
+	)
+
+	tests := []struct {
+		name     string
+		chat     *tgbotapi.Chat
+		wantText string
+	}{
+		{
+			name:     "private chat",
+			chat:     &tgbotapi.Chat{ID: 5, Type: "private"},
+			wantText: rewritten + "\n\n❌ https://www.amazon.fr/dp/B00TEST456\nThis Amazon marketplace is not supported. Supported marketplaces: amazon.it\n\n",
+		},
+		{
+			name:     "group",
+			chat:     &tgbotapi.Chat{ID: 5, Type: "group"},
+			wantText: rewritten,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			msg := &tgbotapi.Message{MessageID: 10, From: &tgbotapi.User{ID: 7}, Chat: tt.chat, Text: text, Entities: entities}
+
+			bot := &sendertest.Recorder{}
+			_, err := HandleMessage(msg, bot, newDependencies(persistence.NewMemoryStore(), nil))
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			want := tgbotapi.NewMessage(5, tt.wantText)
+			want.ParseMode = "HTML"
+			if !tt.chat.IsPrivate() {
+				want.ReplyToMessageID = 10
+			}
+
+			if len(bot.Sent) != 1 || !reflect.DeepEqual(bot.Sent[0], want) {
+				t.Errorf("HandleMessage() sent %+v, want %+v", bot.Sent, want)
+			}
+
+		})
+	}
+
+}
+
+func TestHandleMessage_groups(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	link := "https://www.amazon.it/dp/B00TEST123"
+	refurl := "https://www.amazon.it/dp/B00TEST123?tag=ref-21"
+	newMessage := func(chatType string, text string, from *tgbotapi.User) *tgbotapi.Message {
+		msg := &tgbotapi.Message{MessageID: 10, From: from, Chat: &tgbotapi.Chat{ID: -100, Type: chatType}, Text: text}
+		if text == link {
+			msg.Entities = []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(link)}}
+		}
+		return msg
+	}
+
+	// newMedia returns a photo with the link in the caption.
+	newMedia := func(caption string, from *tgbotapi.User) *tgbotapi.Message {
+		msg := newMessage("supergroup", "", from)
+		msg.Photo = []tgbotapi.PhotoSize{{FileID: "photo"}}
+		msg.Caption = caption
+		msg.CaptionEntities = []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(caption)}}
+		return msg
+	}
+
+	user := &tgbotapi.User{ID: 7, FirstName: "Mario"}
+	tests := []struct {
+		name         string
+		msg          *tgbotapi.Message
+		repost       bool
+		requestErr   error
+		wantSent     []tgbotapi.Chattable
+		wantRequests int
+	}{
+		{"group without links", newMessage("supergroup", "hello", user), false, nil, nil, 0},
+		{
+			"group reply",
+			newMessage("group", link, user),
+			false, nil,
+			[]tgbotapi.Chattable{tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100, ReplyToMessageID: 10}, Text: "➡️ " + refurl + "\n\n"}},
+			1,
+		},
+		{
+			"group repost",
+			newMessage("supergroup", link, user),
+			true, nil,
+			[]tgbotapi.Chattable{
+				tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100}, Text: "Mario:\n" + refurl, ParseMode: "HTML"},
+				tgbotapi.NewDeleteMessage(-100, 10),
+			},
+			1,
+		},
+		{
+			"repost without permissions",
+			newMessage("supergroup", link, user),
+			true, errors.New("Bad Request: message can't be deleted"),
+			[]tgbotapi.Chattable{
+				tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100}, Text: "Mario:\n" + refurl, ParseMode: "HTML"},
+				tgbotapi.NewDeleteMessage(-100, 10),
+				tgbotapi.NewDeleteMessage(-100, 101),
+				tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100, ReplyToMessageID: 10}, Text: "➡️ " + refurl + "\n\n"},
+			},
+			1,
+		},
+		{
+			"media repost",
+			newMedia(link, user),
+			true, nil,
+			[]tgbotapi.Chattable{tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100, ReplyToMessageID: 10}, Text: "➡️ " + refurl + "\n\n"}},
+			1,
+		},
+		{
+			"channel post",
+			newMessage("channel", link, nil),
+			false, nil,
+			[]tgbotapi.Chattable{tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100, ReplyToMessageID: 10}, Text: "➡️ " + refurl + "\n\n"}},
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			repository.GroupRepost = tt.repost
+			defer func() { repository.GroupRepost = false }()
+
+			bot := &sendertest.Recorder{RequestErr: tt.requestErr}
+			store := persistence.NewMemoryStore()
+
+			_, err := HandleMessage(tt.msg, bot, newDependencies(store, nil))
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(bot.Sent, tt.wantSent) {
+				t.Errorf("HandleMessage() sent %+v, want %+v", bot.Sent, tt.wantSent)
+			}
+
+			requests, _, err := store.GetRequestsPage(persistence.RequestQuery{}, "", 10)
+			if err != nil {
+				t.Fatalf("GetRequestsPage() error = %v", err)
+			}
+
+			if len(requests) != tt.wantRequests {
+				t.Errorf("HandleMessage() saved %d requests, want %d", len(requests), tt.wantRequests)
+			}
+
+		})
+	}
+
+}
+
+func Test_repostMessage_sendFailure(t *testing.T) {
+
+	link := "https://www.amazon.it/dp/B00TEST123"
+	msg := &tgbotapi.Message{MessageID: 10, Chat: &tgbotapi.Chat{ID: -100, Type: "supergroup"}, Text: link}
+	bot := &sendertest.Recorder{SendErr: func(c tgbotapi.Chattable) error {
+		return errors.New("Too Many Requests: retry after 5")
+	}}
+
+	tUTF16 := utf16.Encode([]rune(link))
+	entities := []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(link)}}
+	if repostMessage(msg, tUTF16, entities, map[string]string{link: link + "?tag=ref-21"}, bot) {
+		t.Error("repostMessage() = true, want false when the repost fails")
+	}
+
+	// The original message must not be deleted.
+	if len(bot.Sent) != 1 {
+		t.Errorf("repostMessage() sent %+v, want only the repost", bot.Sent)
+	}
+
+}
+
+func TestHandleEditedMessage(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	first, second := "https://www.amazon.it/dp/B00TEST123", "https://www.amazon.it/dp/B00TEST456"
+	newMessage := func(chatType string, links ...string) *tgbotapi.Message {
+		msg := &tgbotapi.Message{MessageID: 10, From: &tgbotapi.User{ID: 7}, Chat: &tgbotapi.Chat{ID: 5, Type: chatType}, Text: "deal"}
+		for _, link := range links {
+			msg.Entities = append(msg.Entities, tgbotapi.MessageEntity{Type: "url", Offset: len(msg.Text) + 1, Length: len(link)})
+			msg.Text += " " + link
+		}
+		return msg
+	}
+
+	// Every step edits the message of the previous one.
+	steps := []struct {
+		name         string
+		msg          *tgbotapi.Message
+		edited       bool
+		wantSent     tgbotapi.Chattable
+		wantRequests int
+	}{
+		{"original", newMessage("private", first), false, tgbotapi.NewMessage(5, "➡️ "+first+"?tag=ref-21\n\n"), 1},
+		{"link added", newMessage("private", first, second), true, tgbotapi.NewEditMessageText(5, 101, "➡️ "+first+"?tag=ref-21\n\n➡️ "+second+"?tag=ref-21\n\n"), 2},
+		{"same links", newMessage("private", first, second), true, nil, 2},
+		{"links removed", newMessage("private"), true, tgbotapi.NewEditMessageText(5, 101, "No URLs found 😢"), 2},
+		{"link added back", newMessage("private", second), true, tgbotapi.NewEditMessageText(5, 101, "➡️ "+second+"?tag=ref-21\n\n"), 3},
+	}
+
+	bot := &sendertest.Recorder{}
+	store := persistence.NewMemoryStore()
+
+	for _, step := range steps {
+
+		sent := len(bot.Sent)
+
+		var err error
+		if step.edited {
+			_, err = HandleEditedMessage(step.msg, bot, newDependencies(store, nil))
+		} else {
+			_, err = HandleMessage(step.msg, bot, newDependencies(store, nil))
+		}
+
+		if err != nil {
+			t.Fatalf("%s: error = %v", step.name, err)
+		}
+
+		var gotSent tgbotapi.Chattable
+		if len(bot.Sent) > sent {
+			gotSent = bot.Sent[len(bot.Sent)-1]
+		}
+
+		if !reflect.DeepEqual(gotSent, step.wantSent) {
+			t.Errorf("%s: sent %+v, want %+v", step.name, gotSent, step.wantSent)
+		}
+
+		requests, _, _ := store.GetRequestsPage(persistence.RequestQuery{}, "", 10)
+		if len(requests) != step.wantRequests {
+			t.Errorf("%s: saved %d requests, want %d", step.name, len(requests), step.wantRequests)
+		}
+
+	}
+
+}
+
+func TestHandleEditedMessage_groups(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	link := "https://www.amazon.it/dp/B00TEST123"
+	msg := &tgbotapi.Message{MessageID: 10, From: &tgbotapi.User{ID: 7}, Chat: &tgbotapi.Chat{ID: -100, Type: "group"}, Text: "hello"}
+
+	bot := &sendertest.Recorder{}
+	store := persistence.NewMemoryStore()
+
+	// The bot didn't reply to the message without links,
+	// so the edit is handled as a new message.
+	_, _ = HandleMessage(msg, bot, newDependencies(store, nil))
+	msg.Text = link
+	msg.Entities = []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(link)}}
+	_, _ = HandleEditedMessage(msg, bot, newDependencies(store, nil))
+
+	// Removing the link deletes the reply.
+	msg.Text, msg.Entities = "hello", nil
+	_, _ = HandleEditedMessage(msg, bot, newDependencies(store, nil))
+
+	want := []tgbotapi.Chattable{
+		tgbotapi.MessageConfig{BaseChat: tgbotapi.BaseChat{ChatID: -100, ReplyToMessageID: 10}, Text: "➡️ " + link + "?tag=ref-21\n\n"},
+		tgbotapi.NewDeleteMessage(-100, 101),
+	}
+
+	if !reflect.DeepEqual(bot.Sent, want) {
+		t.Errorf("HandleEditedMessage() sent %+v, want %+v", bot.Sent, want)
+	}
+
+}
+
+func TestHandleMessage_products(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	products := metadatatest.Provider{
+		"B00TEST123": {ASIN: "B00TEST123", Title: "Headphones <Pro>", Price: "€ 29,99", ImageURL: "https://m.media-amazon.com/test.jpg"},
+		"B00TEST456": {ASIN: "B00TEST456", Title: "Cable"},
+	}
+
+	newMessage := func(asins ...string) *tgbotapi.Message {
+		msg := &tgbotapi.Message{MessageID: 10, From: &tgbotapi.User{ID: 7}, Chat: &tgbotapi.Chat{ID: 5, Type: "private"}}
+		for _, asin := range asins {
+			link := "https://www.amazon.it/dp/" + asin
+			msg.Entities = append(msg.Entities, tgbotapi.MessageEntity{Type: "url", Offset: len(msg.Text), Length: len(link)})
+			msg.Text += link + " "
+		}
+		return msg
+	}
+
+	photo := tgbotapi.NewPhotoShare(5, "https://m.media-amazon.com/test.jpg")
+	photo.Caption = "<b>Headphones &lt;Pro&gt;</b>\n💰 € 29,99\n➡️ https://www.amazon.it/dp/B00TEST123?tag=ref-21\n\n"
+	photo.ParseMode = "HTML"
+
+	list := tgbotapi.NewMessage(5, "<b>Cable</b>\n➡️ https://www.amazon.it/dp/B00TEST456?tag=ref-21\n\n➡️ https://www.amazon.it/dp/B00TEST789?tag=ref-21\n\n")
+	list.ParseMode = "HTML"
+
+	fallback := tgbotapi.NewMessage(5, photo.Caption)
+	fallback.ParseMode = "HTML"
+
+	tests := []struct {
+		name       string
+		msg        *tgbotapi.Message
+		failPhotos bool
+		wantSent   []tgbotapi.Chattable
+	}{
+		{"photo", newMessage("B00TEST123"), false, []tgbotapi.Chattable{photo}},
+		{"list with unknown product", newMessage("B00TEST456", "B00TEST789"), false, []tgbotapi.Chattable{list}},
+		{"photo failure", newMessage("B00TEST123"), true, []tgbotapi.Chattable{photo, fallback}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			bot := &sendertest.Recorder{SendErr: failPhotos(tt.failPhotos)}
+			_, err := HandleMessage(tt.msg, bot, newDependencies(persistence.NewMemoryStore(), products))
+			if err != nil {
+				t.Fatalf("HandleMessage() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(bot.Sent, tt.wantSent) {
+				t.Errorf("HandleMessage() sent %+v, want %+v", bot.Sent, tt.wantSent)
+			}
+
+		})
+	}
+
+}
+
+func TestHandleEditedMessage_photo(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	products := metadatatest.Provider{
+		"B00TEST123": {ASIN: "B00TEST123", Title: "Headphones", ImageURL: "https://m.media-amazon.com/test.jpg"},
+		"B00TEST456": {ASIN: "B00TEST456", Title: "Cable"},
+	}
+
+	newMessage := func(asin string) *tgbotapi.Message {
+		link := "https://www.amazon.it/dp/" + asin
+		return &tgbotapi.Message{
+			MessageID: 10,
+			From:      &tgbotapi.User{ID: 7},
+			Chat:      &tgbotapi.Chat{ID: 5, Type: "private"},
+			Text:      link,
+			Entities:  []tgbotapi.MessageEntity{{Type: "url", Offset: 0, Length: len(link)}},
+		}
+	}
+
+	bot := &sendertest.Recorder{}
+	store := persistence.NewMemoryStore()
+	if _, err := HandleMessage(newMessage("B00TEST123"), bot, newDependencies(store, products)); err != nil {
+		t.Fatalf("HandleMessage() error = %v", err)
+	}
+
+	if _, err := HandleEditedMessage(newMessage("B00TEST456"), bot, newDependencies(store, products)); err != nil {
+		t.Fatalf("HandleEditedMessage() error = %v", err)
+	}
+
+	// The photo is deleted and replaced by a text reply.
+	text := tgbotapi.NewMessage(5, "<b>Cable</b>\n➡️ https://www.amazon.it/dp/B00TEST456?tag=ref-21\n\n")
+	text.ParseMode = "HTML"
+	wantSent := []tgbotapi.Chattable{tgbotapi.NewDeleteMessage(5, 101), text}
+	if len(bot.Sent) != 3 || !reflect.DeepEqual(bot.Sent[1:], wantSent) {
+		t.Errorf("HandleEditedMessage() sent %+v, want %+v after the photo", bot.Sent, wantSent)
+	}
+
+	reply, found, err := store.GetReply(5, 10)
+	if err != nil || !found || reply.ReplyID != 103 || reply.IsPhoto {
+		t.Errorf("GetReply() = %+v, %v, %v, want the text reply 103", reply, found, err)
+	}
+
+}
+
+func TestHandleMessage_feedback(t *testing.T) {
+
+	repository.ReferralIDs = map[string]string{"amazon.it": "ref-21"}
+	defer func() { repository.ReferralIDs = nil }()
+
+	newMessage := func(chatType string, links ...string) *tgbotapi.Message {
+		msg := &tgbotapi.Message{MessageID: 10, From: &tgbotapi.User{ID: 7}, Chat: &tgbotapi.Chat{ID: 5, Type: chatType}}
+		for _, link := range links {
+			msg.Entities = append(msg.Entities, tgbotapi.MessageEntity{Type: "url", Offset: len(msg.Text), Length: len(link)})
+			msg.Text += link + " "
+		}
+		return msg
+	}
+
+	mixed := tgbotapi.NewMessage(5, "➡️ https://www.amazon.it/dp/B00TEST123?tag=ref-21\n\n"+
+		"❌ https://www.amazon.de/dp/B00TEST456\nThis Amazon marketplace is not supported. Supported marketplaces: amazon.it\n\n"+
+		"❌ https://www.amazon.it/gp/help\nThis link doesn't point to a product 🔍\n\n")
+
+	failures := tgbotapi.NewMessage(5, "❌ https://www.example.com/\nThis is not an Amazon link 🤷\n\n")
+
+	group := tgbotapi.NewMessage(5, "➡️ https://www.amazon.it/dp/B00TEST123?tag=ref-21\n\n")
+	group.ReplyToMessageID = 10
+
+	tests := []struct {
+		name     string
+		msg      *tgbotapi.Message
+		wantErr  bool
+		wantSent []tgbotapi.Chattable
+	}{
+		{
+			"private with failures",
+			newMessage("private", "https://www.amazon.it/dp/B00TEST123", "https://www.amazon.de/dp/B00TEST456", "https://www.amazon.it/gp/help"),
+			false,
+			[]tgbotapi.Chattable{mixed},
+		},
+		{"private without referrals", newMessage("private", "https://www.example.com/"), true, []tgbotapi.Chattable{failures}},
+		{
+			"group hides failures",
+			newMessage("group", "https://www.amazon.it/dp/B00TEST123", "https://www.amazon.de/dp/B00TEST456"),
+			false,
+			[]tgbotapi.Chattable{group},
+		},
+		{"group without referrals", newMessage("group", "https://www.example.com/"), false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			bot := &sendertest.Recorder{}
+			_, err := HandleMessage(tt.msg, bot, newDependencies(persistence.NewMemoryStore(), nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HandleMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(bot.Sent, tt.wantSent) {
+				t.Errorf("HandleMessage() sent %+v, want %+v", bot.Sent, tt.wantSent)
+			}
+
+		})
+	}
+
+}
+
+func Test_isNotModified(t *testing.T) {
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"not modified", tgbotapi.Error{Message: "Bad Request: message is not modified: specified new message content and reply markup are exactly the same"}, true},
+		{"wrapped", errors.Wrap(tgbotapi.Error{Message: "Bad Request: message is not modified"}, "editReply"), true},
+		{"other API error", tgbotapi.Error{Message: "Bad Request: message to edit not found"}, false},
+		{"network error", errors.New("message is not modified"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNotModified(tt.err); got != tt.want {
+				t.Errorf("isNotModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+}
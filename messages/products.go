@@ -0,0 +1,134 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"context"
+	"html"
+	"log"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+)
+
+// maxTitleLength is the maximum length, in runes, of the product
+// titles, so that photo captions stay within Telegram's limits.
+const maxTitleLength = 200
+
+// lookupProducts returns the details of the products the results
+// point to, in the same order. The products that can't be found, and
+// the links that weren't converted, are left empty. It returns nil if
+// there's no provider or if the reply won't contain the list of the
+// referral URLs.
+func lookupProducts(ctx context.Context, results []urlwork.ReferralResult, products metadata.Provider) []metadata.Product {
+
+	if products == nil || repository.ReplyMode == repository.ReplyModeRewrite {
+		return nil
+	}
+
+	items := make([]metadata.Item, len(results))
+	for i, result := range results {
+		if result.Err == nil {
+			referral := result.Referral
+			items[i] = metadata.Item{Marketplace: referral.Marketplace, ASIN: referral.ASIN, Tag: referral.Tag}
+		}
+	}
+
+	return metadata.LookupAll(ctx, products, items)
+
+}
+
+// sendProductPhoto sends the photo of the product, with its details
+// as caption, as the reply to a message with a single referral URL.
+// It returns false if there's no photo or if it couldn't be sent.
+func sendProductPhoto(msg *tgbotapi.Message, results []urlwork.ReferralResult, details []metadata.Product, bot sender.Sender, store persistence.Store) bool {
+
+	if len(results) != 1 || results[0].Err != nil || len(details) != 1 || details[0].ImageURL == "" {
+		return false
+	}
+
+	photo := tgbotapi.NewPhotoShare(msg.Chat.ID, details[0].ImageURL)
+	photo.Caption = productsText(results, details)
+	photo.ParseMode = "HTML"
+	if !msg.Chat.IsPrivate() {
+		photo.ReplyToMessageID = msg.MessageID
+	}
+
+	err := sendAndSave(msg, photo, []string{results[0].Referral.URL}, bot, store)
+	if err != nil {
+		log.Println("sendProductPhoto: unable to send photo, sending the link instead:", err)
+		return false
+	}
+
+	return true
+
+}
+
+// productsText returns the list of the referral URLs as HTML,
+// with the title and price of the products, when available,
+// explaining why the other links couldn't be converted.
+func productsText(results []urlwork.ReferralResult, details []metadata.Product) string {
+
+	builder := strings.Builder{}
+	for i, result := range results {
+
+		if result.Err != nil {
+			builder.WriteString(failureHTML(result))
+			continue
+		}
+
+		if i < len(details) && details[i].Title != "" {
+			builder.WriteString("<b>" + html.EscapeString(truncate(details[i].Title, maxTitleLength)) + "</b>\n")
+			if details[i].Price != "" {
+				builder.WriteString("💰 " + html.EscapeString(details[i].Price) + "\n")
+			}
+		}
+
+		builder.WriteString("➡️ " + html.EscapeString(result.Referral.URL) + "\n\n")
+
+	}
+
+	return builder.String()
+
+}
+
+// failuresHTML returns, as HTML, the explanations of
+// the links that couldn't be converted, if any.
+func failuresHTML(results []urlwork.ReferralResult) string {
+
+	builder := strings.Builder{}
+	for _, result := range results {
+		if result.Err != nil {
+			builder.WriteString(failureHTML(result))
+		}
+	}
+
+	return builder.String()
+
+}
+
+// failureHTML returns, as HTML, the link of a result
+// and the reason it couldn't be converted.
+func failureHTML(result urlwork.ReferralResult) string {
+	return "❌ " + html.EscapeString(result.Link) + "\n" + html.EscapeString(explainError(result.Err)) + "\n\n"
+}
+
+// truncate returns the text cut to the given number of runes.
+func truncate(text string, length int) string {
+
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+
+	return string(runes[:length-1]) + "…"
+
+}
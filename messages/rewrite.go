@@ -0,0 +1,125 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package messages
+
+import (
+	"sort"
+	"unicode/utf16"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/utility"
+)
+
+// replacement is the replacement of a UTF-16 range of a text.
+type replacement struct {
+	start, end int
+	text       []uint16
+}
+
+// RewriteLinks returns the UTF-16 encoded text of a message with its
+// links replaced by their referral version, when available, and the
+// entities with their offsets moved accordingly, so that formatting
+// survives. URL entities are replaced in the text, while text_link
+// entities keep their text and point to the referral URL.
+func RewriteLinks(tUTF16 []uint16, entities []tgbotapi.MessageEntity, refURLs map[string]string) ([]uint16, []tgbotapi.MessageEntity) {
+
+	var replacements []replacement
+	rewrittenEntities := make([]tgbotapi.MessageEntity, 0, len(entities))
+
+	for _, entity := range entities {
+
+		start, end := entity.Offset, entity.Offset+entity.Length
+		if start < 0 || end > len(tUTF16) {
+			continue
+		}
+
+		switch entity.Type {
+		case "url":
+			if refurl, ok := refURLs[string(utf16.Decode(tUTF16[start:end]))]; ok {
+				replacements = append(replacements, replacement{start, end, utf16.Encode([]rune(refurl))})
+			}
+		case "text_link":
+			if refurl, ok := refURLs[entity.URL]; ok {
+				entity.URL = refurl
+			}
+		}
+
+		rewrittenEntities = append(rewrittenEntities, entity)
+
+	}
+
+	replacements = withoutOverlaps(replacements)
+
+	rewritten := make([]uint16, 0, len(tUTF16))
+	last := 0
+	for _, r := range replacements {
+		rewritten = append(rewritten, tUTF16[last:r.start]...)
+		rewritten = append(rewritten, r.text...)
+		last = r.end
+	}
+	rewritten = append(rewritten, tUTF16[last:]...)
+
+	for i, entity := range rewrittenEntities {
+		start := movedOffset(entity.Offset, replacements, false)
+		end := movedOffset(entity.Offset+entity.Length, replacements, true)
+		rewrittenEntities[i].Offset, rewrittenEntities[i].Length = start, end-start
+	}
+
+	return rewritten, rewrittenEntities
+
+}
+
+// withoutOverlaps sorts the replacements by offset,
+// dropping the ones overlapping a previous one.
+func withoutOverlaps(replacements []replacement) []replacement {
+
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].start < replacements[j].start
+	})
+
+	result := replacements[:0]
+	last := 0
+	for _, r := range replacements {
+		if r.start >= last {
+			result = append(result, r)
+			last = r.end
+		}
+	}
+
+	return result
+
+}
+
+// movedOffset returns where a UTF-16 offset of the original text
+// ends up in the rewritten one. Offsets inside a replaced range are
+// moved to its start or, if they are the end of an entity, to its end.
+func movedOffset(offset int, replacements []replacement, isEnd bool) int {
+
+	shift := 0
+	for _, r := range replacements {
+
+		switch {
+		case offset >= r.end:
+			shift += len(r.text) - (r.end - r.start)
+		case offset > r.start && isEnd:
+			return r.start + shift + len(r.text)
+		case offset > r.start:
+			return r.start + shift
+		default:
+			return offset + shift
+		}
+
+	}
+
+	return offset + shift
+
+}
+
+// rewrittenHTML returns the text of a message, with its links replaced
+// by their referral version, as HTML that preserves its formatting.
+func rewrittenHTML(tUTF16 []uint16, entities []tgbotapi.MessageEntity, refURLs map[string]string) string {
+	return utility.FormatEntitiesHTML(RewriteLinks(tUTF16, entities, refURLs))
+}
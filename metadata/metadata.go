@@ -0,0 +1,94 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metadata contains the providers of the
+// details of the products the referral links point to.
+package metadata
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Product contains the details of an Amazon product.
+// Price is formatted for display in the currency of the
+// marketplace and may be empty, e.g. if the product is
+// out of stock, as may ImageURL.
+type Product struct {
+	ASIN     string
+	Title    string
+	Price    string
+	ImageURL string
+}
+
+// Provider looks up the details of the products.
+type Provider interface {
+	// Lookup returns the details of the products with the given
+	// ASINs in an Amazon marketplace, e.g. amazon.it, indexed by
+	// ASIN. The products that can't be found are left out. The
+	// tag is the referral ID used in that marketplace.
+	Lookup(ctx context.Context, marketplace string, asins []string, tag string) (map[string]Product, error)
+}
+
+// Item identifies a product to look up.
+type Item struct {
+	Marketplace string
+	ASIN        string
+	Tag         string
+}
+
+// LookupAll returns the details of the products of the items, in the
+// same order, making a single lookup for the items with the same
+// marketplace and tag and running the lookups concurrently.
+// The products that can't be found, and the items without an
+// ASIN, are left empty.
+func LookupAll(ctx context.Context, provider Provider, items []Item) []Product {
+
+	type group struct {
+		marketplace string
+		tag         string
+	}
+
+	asins := make(map[group][]string)
+	for _, item := range items {
+		if item.ASIN != "" {
+			key := group{item.Marketplace, item.Tag}
+			asins[key] = append(asins[key], item.ASIN)
+		}
+	}
+
+	mutex := sync.Mutex{}
+	found := make(map[group]map[string]Product, len(asins))
+	wg := sync.WaitGroup{}
+	wg.Add(len(asins))
+
+	for key, groupASINs := range asins {
+		go func(key group, groupASINs []string) {
+
+			defer wg.Done()
+			products, err := provider.Lookup(ctx, key.marketplace, groupASINs, key.tag)
+			if err != nil {
+				log.Println("LookupAll: unable to find products:", err)
+			}
+
+			mutex.Lock()
+			found[key] = products
+			mutex.Unlock()
+
+		}(key, groupASINs)
+	}
+
+	wg.Wait()
+
+	products := make([]Product, len(items))
+	for i, item := range items {
+		if item.ASIN != "" {
+			products[i] = found[group{item.Marketplace, item.Tag}][item.ASIN]
+		}
+	}
+
+	return products
+
+}
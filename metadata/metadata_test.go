@@ -0,0 +1,78 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// recordingProvider is a Provider that records its lookups
+// and finds the products whose ASIN ends with 1.
+type recordingProvider struct {
+	mutex   sync.Mutex
+	lookups []string
+}
+
+func (p *recordingProvider) Lookup(_ context.Context, marketplace string, asins []string, tag string) (map[string]Product, error) {
+
+	p.mutex.Lock()
+	p.lookups = append(p.lookups, marketplace+" "+tag+" "+strings.Join(asins, ","))
+	p.mutex.Unlock()
+
+	if marketplace == "amazon.fr" {
+		return nil, errors.New("Lookup: unavailable")
+	}
+
+	products := make(map[string]Product)
+	for _, asin := range asins {
+		if strings.HasSuffix(asin, "1") {
+			products[asin] = Product{ASIN: asin, Title: marketplace}
+		}
+	}
+
+	return products, nil
+
+}
+
+func TestLookupAll(t *testing.T) {
+
+	provider := &recordingProvider{}
+	items := []Item{
+		{Marketplace: "amazon.it", ASIN: "B00TEST001", Tag: "it-21"},
+		{Marketplace: "amazon.de", ASIN: "B00TEST011", Tag: "de-21"},
+		{},
+		{Marketplace: "amazon.it", ASIN: "B00TEST002", Tag: "it-21"},
+		{Marketplace: "amazon.it", ASIN: "B00TEST021", Tag: "it-21"},
+		{Marketplace: "amazon.fr", ASIN: "B00TEST031", Tag: "fr-21"},
+	}
+
+	got := LookupAll(context.Background(), provider, items)
+	want := []Product{
+		{ASIN: "B00TEST001", Title: "amazon.it"},
+		{ASIN: "B00TEST011", Title: "amazon.de"},
+		{},
+		{},
+		{ASIN: "B00TEST021", Title: "amazon.it"},
+		{},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupAll() = %+v, want %+v", got, want)
+	}
+
+	sort.Strings(provider.lookups)
+	wantLookups := []string{"amazon.de de-21 B00TEST011", "amazon.fr fr-21 B00TEST031", "amazon.it it-21 B00TEST001,B00TEST002,B00TEST021"}
+	if !reflect.DeepEqual(provider.lookups, wantLookups) {
+		t.Errorf("LookupAll() looked up %v, want %v", provider.lookups, wantLookups)
+	}
+
+}
@@ -0,0 +1,38 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metadatatest provides a metadata.Provider
+// that looks up the products without a network.
+package metadatatest
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
+)
+
+// Provider is a metadata.Provider that returns
+// the products from a map indexed by ASIN.
+type Provider map[string]metadata.Product
+
+// Lookup returns the products with the given ASINs that are
+// in the map, or an error if none of them is.
+func (p Provider) Lookup(_ context.Context, marketplace string, asins []string, tag string) (map[string]metadata.Product, error) {
+
+	products := make(map[string]metadata.Product, len(asins))
+	for _, asin := range asins {
+		if product, ok := p[asin]; ok {
+			products[asin] = product
+		}
+	}
+
+	if len(products) == 0 {
+		return nil, errors.Errorf("Lookup: products %v not found in %s", asins, marketplace)
+	}
+
+	return products, nil
+
+}
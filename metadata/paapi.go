@@ -0,0 +1,235 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	v4 "github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// paapiService is the name of the Product Advertising API
+	// service, used to sign the requests.
+	paapiService = "ProductAdvertisingAPI"
+	// paapiGetItemsPath is the path of the GetItems operation.
+	paapiGetItemsPath = "/paapi5/getitems"
+	// paapiGetItemsTarget is the target of the GetItems operation.
+	paapiGetItemsTarget = "com.amazon.paapi5.v1.ProductAdvertisingAPIv1.GetItems"
+
+	// paapiMaxItems is the maximum number of
+	// ASINs looked up with a single request.
+	paapiMaxItems = 10
+	// paapiTimeout is the default timeout of the requests.
+	paapiTimeout = 5 * time.Second
+	// maxPAAPIResponseSize is the maximum size
+	// of a response body, in bytes.
+	maxPAAPIResponseSize = 1 << 20
+)
+
+// paapiRegions maps the Amazon marketplaces to the region of
+// their Product Advertising API endpoint.
+var paapiRegions = map[string]string{
+	"amazon.ae":     "eu-west-1",
+	"amazon.ca":     "us-east-1",
+	"amazon.co.jp":  "us-west-2",
+	"amazon.co.uk":  "eu-west-1",
+	"amazon.com":    "us-east-1",
+	"amazon.com.au": "us-west-2",
+	"amazon.com.be": "eu-west-1",
+	"amazon.com.br": "us-east-1",
+	"amazon.com.mx": "us-east-1",
+	"amazon.com.tr": "eu-west-1",
+	"amazon.de":     "eu-west-1",
+	"amazon.eg":     "eu-west-1",
+	"amazon.es":     "eu-west-1",
+	"amazon.fr":     "eu-west-1",
+	"amazon.in":     "eu-west-1",
+	"amazon.it":     "eu-west-1",
+	"amazon.nl":     "eu-west-1",
+	"amazon.pl":     "eu-west-1",
+	"amazon.sa":     "eu-west-1",
+	"amazon.se":     "eu-west-1",
+	"amazon.sg":     "us-west-2",
+}
+
+// PAAPIProvider is a Provider that uses the GetItems
+// operation of the Amazon Product Advertising API 5.0.
+// The tag used in the lookups must be registered for
+// the API in the Amazon Associates program.
+type PAAPIProvider struct {
+	Signer *v4.Signer
+	// Endpoint replaces the API endpoint of the
+	// marketplaces, e.g. https://webservices.amazon.it.
+	// It's meant for tests.
+	Endpoint string
+	Client   *http.Client
+}
+
+// NewPAAPIProvider returns a PAAPIProvider
+// with the given credentials.
+func NewPAAPIProvider(accessKey string, secretKey string) *PAAPIProvider {
+
+	return &PAAPIProvider{
+		Signer: v4.NewSigner(credentials.NewStaticCredentials(accessKey, secretKey, "")),
+		Client: &http.Client{Timeout: paapiTimeout},
+	}
+
+}
+
+// paapiGetItemsRequest is the body of a GetItems request.
+type paapiGetItemsRequest struct {
+	ItemIds     []string
+	Marketplace string
+	PartnerTag  string
+	PartnerType string
+	Resources   []string
+}
+
+// paapiGetItemsResponse is the body of a GetItems response.
+type paapiGetItemsResponse struct {
+	ItemsResult struct {
+		Items []struct {
+			ASIN     string
+			ItemInfo struct {
+				Title struct {
+					DisplayValue string
+				}
+			}
+			Offers struct {
+				Listings []struct {
+					Price struct {
+						DisplayAmount string
+					}
+				}
+			}
+			Images struct {
+				Primary struct {
+					Large struct {
+						URL string
+					}
+				}
+			}
+		}
+	}
+	Errors []struct {
+		Code    string
+		Message string
+	}
+}
+
+// Lookup returns the title, price and image of the products, with
+// a GetItems request for every paapiMaxItems ASINs. The products the
+// API can't return, e.g. because they're not accessible, are left out.
+func (p *PAAPIProvider) Lookup(ctx context.Context, marketplace string, asins []string, tag string) (products map[string]Product, err error) {
+
+	region, ok := paapiRegions[marketplace]
+	if !ok {
+		err = errors.Errorf("Lookup: marketplace %s is not supported by the Product Advertising API", marketplace)
+		return
+	}
+
+	products = make(map[string]Product, len(asins))
+	for start := 0; start < len(asins); start += paapiMaxItems {
+
+		end := start + paapiMaxItems
+		if end > len(asins) {
+			end = len(asins)
+		}
+
+		err = p.getItems(ctx, marketplace, region, asins[start:end], tag, products)
+		if err != nil {
+			err = errors.Errorf("Lookup: %s", err)
+			return
+		}
+
+	}
+
+	return
+
+}
+
+// getItems performs a GetItems request and adds
+// the products it returns to the map.
+func (p *PAAPIProvider) getItems(ctx context.Context, marketplace string, region string, asins []string, tag string, products map[string]Product) error {
+
+	body, err := json.Marshal(paapiGetItemsRequest{
+		ItemIds:     asins,
+		Marketplace: "www." + marketplace,
+		PartnerTag:  tag,
+		PartnerType: "Associates",
+		Resources:   []string{"ItemInfo.Title", "Offers.Listings.Price", "Images.Primary.Large"},
+	})
+
+	if err != nil {
+		return errors.Errorf("getItems: error while marshaling the request: %s", err)
+	}
+
+	endpoint := p.Endpoint
+	if endpoint == "" {
+		endpoint = "https://webservices." + marketplace
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint+paapiGetItemsPath, bytes.NewReader(body))
+	if err != nil {
+		return errors.Errorf("getItems: unable to create the request: %s", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Content-Encoding", "amz-1.0")
+	req.Header.Set("X-Amz-Target", paapiGetItemsTarget)
+
+	_, err = p.Signer.Sign(req, bytes.NewReader(body), paapiService, region, time.Now())
+	if err != nil {
+		return errors.Errorf("getItems: unable to sign the request: %s", err)
+	}
+
+	resp, err := p.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Errorf("getItems: error while performing the request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var response paapiGetItemsResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxPAAPIResponseSize)).Decode(&response)
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	// The errors about some of the items, e.g. ItemNotAccessible,
+	// come with the items that could be found.
+	if len(response.Errors) > 0 && len(response.ItemsResult.Items) == 0 {
+		return errors.Errorf("getItems: %s: %s", response.Errors[0].Code, response.Errors[0].Message)
+	}
+
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return errors.Errorf("getItems: unexpected response with status %d: %v", resp.StatusCode, err)
+	}
+
+	for _, item := range response.ItemsResult.Items {
+
+		product := Product{
+			ASIN:     item.ASIN,
+			Title:    item.ItemInfo.Title.DisplayValue,
+			ImageURL: item.Images.Primary.Large.URL,
+		}
+
+		if len(item.Offers.Listings) > 0 {
+			product.Price = item.Offers.Listings[0].Price.DisplayAmount
+		}
+
+		products[item.ASIN] = product
+
+	}
+
+	return nil
+
+}
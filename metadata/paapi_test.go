@@ -0,0 +1,168 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPAAPIProvider_Lookup(t *testing.T) {
+
+	const item = `{"ItemsResult":{"Items":[{"ASIN":"B00TEST123","ItemInfo":{"Title":{"DisplayValue":"Headphones"}},"Offers":{"Listings":[{"Price":{"DisplayAmount":"€ 29,99"}}]},"Images":{"Primary":{"Large":{"URL":"https://m.media-amazon.com/images/I/test.jpg"}}}}]}}`
+	headphones := Product{ASIN: "B00TEST123", Title: "Headphones", Price: "€ 29,99", ImageURL: "https://m.media-amazon.com/images/I/test.jpg"}
+
+	tests := []struct {
+		name        string
+		marketplace string
+		status      int
+		response    string
+		want        map[string]Product
+		wantErr     bool
+	}{
+		{
+			name:        "product",
+			marketplace: "amazon.it",
+			status:      http.StatusOK,
+			response:    item,
+			want:        map[string]Product{"B00TEST123": headphones},
+		},
+		{
+			name:        "some items not accessible",
+			marketplace: "amazon.it",
+			status:      http.StatusOK,
+			response:    `{"Errors":[{"Code":"ItemNotAccessible","Message":"The ItemId B00TEST456 is not accessible through the Product Advertising API."}],` + item[1:],
+			want:        map[string]Product{"B00TEST123": headphones},
+		},
+		{
+			name:        "no offers",
+			marketplace: "amazon.it",
+			status:      http.StatusOK,
+			response:    `{"ItemsResult":{"Items":[{"ASIN":"B00TEST123","ItemInfo":{"Title":{"DisplayValue":"Headphones"}}}]}}`,
+			want:        map[string]Product{"B00TEST123": {ASIN: "B00TEST123", Title: "Headphones"}},
+		},
+		{
+			name:        "item not accessible",
+			marketplace: "amazon.it",
+			status:      http.StatusOK,
+			response:    `{"Errors":[{"Code":"ItemNotAccessible","Message":"The ItemId B00TEST123 is not accessible through the Product Advertising API."}]}`,
+			wantErr:     true,
+		},
+		{
+			name:        "throttled",
+			marketplace: "amazon.it",
+			status:      http.StatusTooManyRequests,
+			response:    `{"Errors":[{"Code":"TooManyRequests","Message":"The request was denied due to request throttling."}]}`,
+			wantErr:     true,
+		},
+		{
+			name:        "unsupported marketplace",
+			marketplace: "amazon.cn",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+				var request paapiGetItemsRequest
+				_ = json.NewDecoder(r.Body).Decode(&request)
+
+				if r.URL.Path != paapiGetItemsPath || r.Header.Get("X-Amz-Target") != paapiGetItemsTarget ||
+					!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/ProductAdvertisingAPI/") {
+					t.Errorf("Lookup() sent an invalid request: %s %v", r.URL.Path, r.Header)
+				}
+
+				if request.Marketplace != "www.amazon.it" || request.PartnerTag != "ref-21" || !reflect.DeepEqual(request.ItemIds, []string{"B00TEST123", "B00TEST456"}) {
+					t.Errorf("Lookup() sent an invalid body: %+v", request)
+				}
+
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+
+			}))
+			defer server.Close()
+
+			provider := NewPAAPIProvider("access", "secret")
+			provider.Endpoint = server.URL
+
+			got, err := provider.Lookup(context.Background(), tt.marketplace, []string{"B00TEST123", "B00TEST456"}, "ref-21")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Lookup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lookup() = %+v, want %+v", got, tt.want)
+			}
+
+		})
+	}
+
+}
+
+func TestPAAPIProvider_Lookup_batches(t *testing.T) {
+
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		var request paapiGetItemsRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		batches = append(batches, request.ItemIds)
+
+		_, _ = w.Write([]byte(`{"ItemsResult":{"Items":[{"ASIN":"` + request.ItemIds[0] + `"}]}}`))
+
+	}))
+	defer server.Close()
+
+	provider := NewPAAPIProvider("access", "secret")
+	provider.Endpoint = server.URL
+
+	asins := make([]string, 12)
+	for i := range asins {
+		asins[i] = fmt.Sprintf("B00TEST%03d", i)
+	}
+
+	got, err := provider.Lookup(context.Background(), "amazon.it", asins, "ref-21")
+	if err != nil || len(got) != 2 {
+		t.Errorf("Lookup() = %v, %v, want the first product of each request", got, err)
+	}
+
+	if len(batches) != 2 || !reflect.DeepEqual(batches[0], asins[:10]) || !reflect.DeepEqual(batches[1], asins[10:]) {
+		t.Errorf("Lookup() sent %v, want requests of at most %d ASINs", batches, paapiMaxItems)
+	}
+
+}
+
+func TestPAAPIProvider_Lookup_deadline(t *testing.T) {
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	provider := NewPAAPIProvider("access", "secret")
+	provider.Endpoint = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := provider.Lookup(ctx, "amazon.it", []string{"B00TEST123"}, "ref-21")
+	if elapsed := time.Since(start); err == nil || elapsed > time.Second {
+		t.Errorf("Lookup() = %v after %s, want an error at the deadline", err, elapsed)
+	}
+
+}
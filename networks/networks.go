@@ -0,0 +1,76 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package networks contains helpers to check
+// which networks the IP addresses belong to.
+package networks
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Parse parses the networks in CIDR notation. Single IP
+// addresses are accepted too and become networks of one address.
+func Parse(cidrs ...string) ([]*net.IPNet, error) {
+
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Errorf("Parse: invalid IP address %s", cidr)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, errors.Errorf("Parse: %s", err)
+		}
+
+		networks = append(networks, network)
+
+	}
+
+	return networks, nil
+
+}
+
+// MustParse is like Parse, but it panics if any of the networks
+// is not valid, so it's meant for the ones known at compile time.
+func MustParse(cidrs ...string) []*net.IPNet {
+
+	networks, err := Parse(cidrs...)
+	if err != nil {
+		panic(err)
+	}
+
+	return networks
+
+}
+
+// Contain returns true if the IP address
+// belongs to any of the networks.
+func Contain(networks []*net.IPNet, ip net.IP) bool {
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+
+}
@@ -0,0 +1,51 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package networks
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		cidrs   []string
+		ip      string
+		want    bool
+		wantErr bool
+	}{
+		{"IPv4 network", []string{"149.154.160.0/20"}, "149.154.167.220", true, false},
+		{"Outside the networks", []string{"149.154.160.0/20", "91.108.4.0/22"}, "203.0.113.7", false, false},
+		{"IPv4 address", []string{"10.0.0.2"}, "10.0.0.2", true, false},
+		{"Next IPv4 address", []string{"10.0.0.2"}, "10.0.0.3", false, false},
+		{"IPv6 address", []string{"2001:db8::1"}, "2001:db8::1", true, false},
+		{"IPv6 network", []string{"fc00::/7"}, "fd12::1", true, false},
+		{"No networks", nil, "10.0.0.2", false, false},
+		{"Invalid network", []string{"10.0.0.0/33"}, "", false, true},
+		{"Invalid address", []string{"localhost"}, "", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			networks, err := Parse(tt.cidrs...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if got := Contain(networks, net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("Contain() = %v, want %v", got, tt.want)
+			}
+
+		})
+	}
+
+}
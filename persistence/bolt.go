@@ -0,0 +1,495 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+var (
+	boltUsersBucket       = []byte("Users")
+	boltRequestsBucket    = []byte("Requests")
+	boltRequestTimeBucket = []byte("RequestsByTime")
+	boltRepliesBucket     = []byte("Replies")
+	boltLinksBucket       = []byte("Links")
+)
+
+// BoltStore is a Store backed by a local BoltDB file,
+// meant for self-hosted deployments.
+// Requests are indexed by their Unix time so that
+// time-based queries don't scan the whole file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, or creates, the BoltDB file at the given path.
+func NewBoltStore(path string) (*BoltStore, error) {
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Errorf("NewBoltStore: unable to open %s: %s", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{boltUsersBucket, boltRequestsBucket, boltRequestTimeBucket, boltRepliesBucket, boltLinksBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Errorf("NewBoltStore: unable to create buckets: %s", err)
+	}
+
+	return &BoltStore{db: db}, nil
+
+}
+
+// Close releases the BoltDB file.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// PutUser saves a user on BoltDB or updates it if they
+// had previously blocked the bot.
+func (s *BoltStore) PutUser(userID int) error {
+
+	err := s.updateUser(userID, func(user *structs.User) {
+		user.HasBlockedBot = false
+	})
+
+	if err != nil {
+		return errors.Errorf("PutUser: unable to save user: %s", err)
+	}
+
+	return nil
+
+}
+
+// UpdateUserBlockStatus updates the HasBlockedBot field according to the input flag.
+func (s *BoltStore) UpdateUserBlockStatus(userID int, hasBlockedBot bool) error {
+
+	err := s.updateUser(userID, func(user *structs.User) {
+		user.HasBlockedBot = hasBlockedBot
+	})
+
+	if err != nil {
+		return errors.Errorf("UpdateUserBlockStatus: unable to update user status: %s", err)
+	}
+
+	return nil
+
+}
+
+// PutAdmin sets the user's IsAdmin field to true,
+// saving the user if they're not saved yet.
+func (s *BoltStore) PutAdmin(userID int) error {
+
+	err := s.updateUser(userID, func(user *structs.User) {
+		user.IsAdmin = true
+	})
+
+	if err != nil {
+		return errors.Errorf("PutAdmin: unable to save admin: %s", err)
+	}
+
+	return nil
+
+}
+
+// IsUserAdmin returns true if the user's IsAdmin field is true.
+func (s *BoltStore) IsUserAdmin(userID int) (isAdmin bool, err error) {
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+
+		value := tx.Bucket(boltUsersBucket).Get(boltUserKey(userID))
+		if value == nil {
+			return nil
+		}
+
+		var user structs.User
+		if err := json.Unmarshal(value, &user); err != nil {
+			return err
+		}
+
+		isAdmin = user.IsAdmin
+		return nil
+
+	})
+
+	if err != nil {
+		err = errors.Errorf("IsUserAdmin: failed to read user: %s", err)
+	}
+
+	return
+
+}
+
+// GetAllUsers returns all the users that didn't block the bot.
+func (s *BoltStore) GetAllUsers() (users []structs.User, err error) {
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUsersBucket).ForEach(func(_, value []byte) error {
+
+			var user structs.User
+			if err := json.Unmarshal(value, &user); err != nil {
+				return err
+			}
+
+			if !user.HasBlockedBot {
+				users = append(users, user)
+			}
+
+			return nil
+
+		})
+	})
+
+	if err != nil {
+		err = errors.Errorf("GetAllUsers: error while reading the users: %s", err)
+	}
+
+	return
+
+}
+
+// CountUsersSince returns the number of users
+// first seen after a certain threshold.
+func (s *BoltStore) CountUsersSince(threshold int64) (count int, err error) {
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+
+		return tx.Bucket(boltUsersBucket).ForEach(func(_, value []byte) error {
+
+			var user structs.User
+			if err := json.Unmarshal(value, &user); err != nil {
+				return err
+			}
+
+			if user.FirstSeen > threshold {
+				count++
+			}
+
+			return nil
+
+		})
+
+	})
+
+	if err != nil {
+		err = errors.Errorf("CountUsersSince: error while reading the users: %s", err)
+	}
+
+	return
+
+}
+
+// PutRequest saves a request on BoltDB and indexes it by time.
+func (s *BoltStore) PutRequest(request structs.Request) error {
+	return s.putRequest(NewRequest(request))
+
+}
+
+// PutRequests saves the requests on BoltDB in a single transaction.
+func (s *BoltStore) PutRequests(requests []structs.Request) error {
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+
+		for _, request := range requests {
+			if err := putRequestTx(tx, NewRequest(request)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		return errors.Errorf("PutRequests: unable to save requests: %s", err)
+	}
+
+	return nil
+
+}
+
+// GetRequestsPage returns at most limit requests selected by the
+// query, sorted by time, starting after the one whose XID is the
+// cursor. It also returns the cursor of the next page.
+func (s *BoltStore) GetRequestsPage(query RequestQuery, cursor string, limit int) (requests []structs.Request, next string, err error) {
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+
+		requestsBucket := tx.Bucket(boltRequestsBucket)
+		timeCursor := tx.Bucket(boltRequestTimeBucket).Cursor()
+
+		// Seeking to the second after Since skips all the older requests.
+		key, requestXID := timeCursor.Seek(boltTimeKey(query.Since+1, ""))
+		if cursor != "" {
+
+			value := requestsBucket.Get([]byte(cursor))
+			if value == nil {
+				return errors.Errorf("unknown cursor %s", cursor)
+			}
+
+			var last structs.Request
+			if err := json.Unmarshal(value, &last); err != nil {
+				return err
+			}
+
+			// The index keys are unique, so seeking to the key of the
+			// cursor and moving to the next one skips it.
+			lastKey := boltTimeKey(last.UnixTime, last.XID)
+			key, requestXID = timeCursor.Seek(lastKey)
+			if key != nil && bytes.Equal(key, lastKey) {
+				key, requestXID = timeCursor.Next()
+			}
+
+		}
+
+		for ; key != nil; key, requestXID = timeCursor.Next() {
+
+			value := requestsBucket.Get(requestXID)
+			if value == nil {
+				continue
+			}
+
+			var request structs.Request
+			if err := json.Unmarshal(value, &request); err != nil {
+				return err
+			}
+
+			if !query.Matches(request) {
+				continue
+			}
+
+			// There's a next page only if it's not empty.
+			if len(requests) == limit {
+				next = requests[limit-1].XID
+				break
+			}
+
+			requests = append(requests, request)
+
+		}
+
+		return nil
+
+	})
+
+	if err != nil {
+		err = errors.Errorf("GetRequestsPage: error while querying the database: %s", err)
+	}
+
+	return
+
+}
+
+// PutReply saves on BoltDB the reply the bot sent to a message,
+// replacing the previous one.
+func (s *BoltStore) PutReply(reply structs.Reply) error {
+
+	reply.MessageKey = structs.ReplyKey(reply.ChatID, reply.MessageID)
+	reply.UnixTime = time.Now().Unix()
+
+	value, err := json.Marshal(reply)
+	if err != nil {
+		return errors.Errorf("PutReply: error while marshaling reply: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRepliesBucket).Put([]byte(reply.MessageKey), value)
+	})
+
+	if err != nil {
+		return errors.Errorf("PutReply: unable to save reply: %s", err)
+	}
+
+	return nil
+
+}
+
+// GetReply returns the reply the bot sent to a message, if any.
+func (s *BoltStore) GetReply(chatID int64, messageID int) (reply structs.Reply, found bool, err error) {
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+
+		value := tx.Bucket(boltRepliesBucket).Get([]byte(structs.ReplyKey(chatID, messageID)))
+		if value == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(value, &reply)
+
+	})
+
+	if err != nil {
+		err = errors.Errorf("GetReply: failed to read reply: %s", err)
+	}
+
+	return
+
+}
+
+// PutLink saves on BoltDB the shortened referral
+// link of a product, replacing the previous one.
+func (s *BoltStore) PutLink(link structs.Link) error {
+
+	link.LinkKey = structs.LinkKey(link.Marketplace, link.ASIN, link.Tag, link.Options)
+	link.UnixTime = time.Now().Unix()
+
+	value, err := json.Marshal(link)
+	if err != nil {
+		return errors.Errorf("PutLink: error while marshaling link: %v", err)
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltLinksBucket).Put([]byte(link.LinkKey), value)
+	})
+
+	if err != nil {
+		return errors.Errorf("PutLink: unable to save link: %s", err)
+	}
+
+	return nil
+
+}
+
+// GetLink returns the shortened referral link of a product, if any.
+func (s *BoltStore) GetLink(marketplace string, asin string, tag string, options string) (link structs.Link, found bool, err error) {
+
+	err = s.db.View(func(tx *bolt.Tx) error {
+
+		value := tx.Bucket(boltLinksBucket).Get([]byte(structs.LinkKey(marketplace, asin, tag, options)))
+		if value == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(value, &link)
+
+	})
+
+	if err != nil {
+		err = errors.Errorf("GetLink: failed to read link: %s", err)
+	}
+
+	return
+
+}
+
+// putRequest saves the request and its time index entry
+// in a single transaction.
+func (s *BoltStore) putRequest(request structs.Request) error {
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return putRequestTx(tx, request)
+	})
+
+	if err != nil {
+		return errors.Errorf("PutRequest: unable to save request: %s", err)
+	}
+
+	return nil
+
+}
+
+// putRequestTx saves the request and its time index entry in
+// the transaction, replacing the entry of a previous version.
+func putRequestTx(tx *bolt.Tx, request structs.Request) error {
+
+	requestsBucket := tx.Bucket(boltRequestsBucket)
+	timeBucket := tx.Bucket(boltRequestTimeBucket)
+
+	if previousValue := requestsBucket.Get([]byte(request.XID)); previousValue != nil {
+
+		var previous structs.Request
+		err := json.Unmarshal(previousValue, &previous)
+		if err != nil {
+			return errors.Errorf("error while unmarshaling request %s: %v", request.XID, err)
+		}
+
+		err = timeBucket.Delete(boltTimeKey(previous.UnixTime, previous.XID))
+		if err != nil {
+			return err
+		}
+
+	}
+
+	value, err := json.Marshal(request)
+	if err != nil {
+		return errors.Errorf("error while marshaling request %s: %v", request.XID, err)
+	}
+
+	err = requestsBucket.Put([]byte(request.XID), value)
+	if err != nil {
+		return err
+	}
+
+	return timeBucket.Put(boltTimeKey(request.UnixTime, request.XID), []byte(request.XID))
+
+}
+
+// updateUser reads the user, creating it if needed, applies
+// the update function and saves it back in a single transaction.
+// The users it creates are first seen now.
+func (s *BoltStore) updateUser(userID int, update func(user *structs.User)) error {
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+
+		bucket := tx.Bucket(boltUsersBucket)
+		key := boltUserKey(userID)
+
+		user := structs.User{TelegramID: userID, FirstSeen: time.Now().Unix()}
+		if value := bucket.Get(key); value != nil {
+			// The users saved before FirstSeen was recorded must keep it zero.
+			user = structs.User{}
+			if err := json.Unmarshal(value, &user); err != nil {
+				return err
+			}
+		}
+
+		update(&user)
+
+		value, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(key, value)
+
+	})
+
+}
+
+// boltUserKey returns the key of a user in the users bucket.
+func boltUserKey(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
+
+// boltTimeKey returns the key of a request in the time index:
+// the Unix time in big endian followed by the request XID.
+// The sign bit is flipped, so that the times before the epoch
+// come before the others.
+func boltTimeKey(unixTime int64, requestXID string) []byte {
+
+	key := make([]byte, 8, 8+len(requestXID))
+	binary.BigEndian.PutUint64(key, uint64(unixTime)^(1<<63))
+	return append(key, requestXID...)
+
+}
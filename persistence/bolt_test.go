@@ -0,0 +1,248 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+func newTestBoltStore(t *testing.T) (*BoltStore, func()) {
+
+	dir, err := ioutil.TempDir("", "refbot")
+	if err != nil {
+		t.Fatalf("newTestBoltStore: unable to create temporary directory: %s", err)
+	}
+
+	store, err := NewBoltStore(filepath.Join(dir, "refbot.db"))
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatalf("newTestBoltStore: %s", err)
+	}
+
+	return store, func() {
+		_ = store.Close()
+		_ = os.RemoveAll(dir)
+	}
+
+}
+
+func TestBoltStore_Users(t *testing.T) {
+
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	for _, userID := range []int{1, 2, 3} {
+		if err := store.PutUser(userID); err != nil {
+			t.Fatalf("PutUser() error = %v", err)
+		}
+	}
+
+	if err := store.UpdateUserBlockStatus(2, true); err != nil {
+		t.Fatalf("UpdateUserBlockStatus() error = %v", err)
+	}
+
+	users, err := store.GetAllUsers()
+	if err != nil {
+		t.Fatalf("GetAllUsers() error = %v", err)
+	}
+
+	// The users are first seen when they're saved.
+	for i := range users {
+		if users[i].FirstSeen == 0 {
+			t.Errorf("GetAllUsers()[%d] has no first-seen time", i)
+		}
+		users[i].FirstSeen = 0
+	}
+
+	want := []structs.User{{TelegramID: 1}, {TelegramID: 3}}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("GetAllUsers() = %v, want %v", users, want)
+	}
+
+	// Putting the user again must unblock them.
+	_ = store.PutUser(2)
+	users, _ = store.GetAllUsers()
+	if len(users) != 3 {
+		t.Errorf("GetAllUsers() after unblock returned %d users, want 3", len(users))
+	}
+
+	isAdmin, err := store.IsUserAdmin(4)
+	if err != nil || isAdmin {
+		t.Errorf("IsUserAdmin() = %v, %v, want false, nil", isAdmin, err)
+	}
+
+	count, err := store.CountUsersSince(time.Now().Add(-time.Hour).Unix())
+	if err != nil || count != 3 {
+		t.Errorf("CountUsersSince() = %d, %v, want 3, nil", count, err)
+	}
+
+	count, err = store.CountUsersSince(time.Now().Add(time.Hour).Unix())
+	if err != nil || count != 0 {
+		t.Errorf("CountUsersSince() in the future = %d, %v, want 0, nil", count, err)
+	}
+
+}
+
+func TestBoltStore_PutAdmin(t *testing.T) {
+
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	testStorePutAdmin(t, store)
+
+}
+
+func TestBoltStore_Replies(t *testing.T) {
+
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	testStoreReplies(t, store)
+
+}
+
+func TestBoltStore_Links(t *testing.T) {
+
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	testStoreLinks(t, store)
+
+}
+
+func TestBoltStore_GetRequestsPage(t *testing.T) {
+
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	testStoreRequestsPage(t, store)
+
+}
+
+func TestBoltStore_PutRequests(t *testing.T) {
+
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	testStorePutRequests(t, store)
+
+}
+
+func TestBoltStore_PutRequests_reimport(t *testing.T) {
+
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	// Importing a request again with a different
+	// time must move it in the time index.
+	for _, unixTime := range []int64{100, 200} {
+		err := store.PutRequests([]structs.Request{{XID: "request", TelegramID: 1, URL: "https://amzn.to/", Time: time.Unix(unixTime, 0)}})
+		if err != nil {
+			t.Fatalf("PutRequests() error = %v", err)
+		}
+	}
+
+	requests, _, err := store.GetRequestsPage(RequestQuery{Since: -1}, "", 10)
+	if err != nil || len(requests) != 1 || requests[0].UnixTime != 200 {
+		t.Errorf("GetRequestsPage() = %v, %v, want the request at 200", requests, err)
+	}
+
+	requests, _, err = store.GetRequestsPage(RequestQuery{Since: 150}, "", 10)
+	if err != nil || len(requests) != 1 {
+		t.Errorf("GetRequestsPage(150) = %v, %v, want the request", requests, err)
+	}
+
+}
+
+func TestBoltStore_GetRequestsPage_timeIndex(t *testing.T) {
+
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	// Insert them out of order to make sure the index sorts them.
+	for _, unixTime := range []int64{300, 100, -100, 200} {
+		err := store.putRequest(structs.Request{
+			XID:        "request-" + time.Unix(unixTime, 0).UTC().Format("150405"),
+			TelegramID: 1,
+			URL:        "https://amzn.to/",
+			Time:       time.Unix(unixTime, 0).UTC(),
+			UnixTime:   unixTime,
+		})
+		if err != nil {
+			t.Fatalf("putRequest() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		threshold int64
+		wantTimes []int64
+	}{
+		{
+			name:      "Before the epoch",
+			threshold: -200,
+			wantTimes: []int64{-100, 100, 200, 300},
+		},
+		{
+			name:      "Negative threshold",
+			threshold: -10,
+			wantTimes: []int64{100, 200, 300},
+		},
+		{
+			name:      "Threshold is exclusive",
+			threshold: 100,
+			wantTimes: []int64{200, 300},
+		},
+		{
+			name:      "Between requests",
+			threshold: 250,
+			wantTimes: []int64{300},
+		},
+		{
+			name:      "No requests",
+			threshold: 300,
+			wantTimes: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			requests, _, err := store.GetRequestsPage(RequestQuery{Since: tt.threshold}, "", 10)
+			if err != nil {
+				t.Errorf("GetRequestsPage() error = %v", err)
+				return
+			}
+
+			var gotTimes []int64
+			for _, request := range requests {
+				gotTimes = append(gotTimes, request.UnixTime)
+			}
+
+			if !reflect.DeepEqual(gotTimes, tt.wantTimes) {
+				t.Errorf("GetRequestsPage() = %v, want %v", gotTimes, tt.wantTimes)
+			}
+
+		})
+	}
+
+	// Requests saved with PutRequest must be indexed as well.
+	if err := store.PutRequest(structs.Request{TelegramID: 2, URL: "https://amzn.to/now"}); err != nil {
+		t.Fatalf("PutRequest() error = %v", err)
+	}
+
+	requests, _, _ := store.GetRequestsPage(RequestQuery{Since: 300}, "", 10)
+	if len(requests) != 1 || requests[0].TelegramID != 2 {
+		t.Errorf("GetRequestsPage() after PutRequest = %v, want the new request", requests)
+	}
+
+}
@@ -0,0 +1,121 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// LinkCache caches the shortened referral links of the products
+// in a Store, for the given TTL. The most recently used links are
+// also kept in memory, so that warm Lambda containers don't need
+// to query the Store. It's safe for concurrent use.
+type LinkCache struct {
+	store Store
+	ttl   time.Duration
+	size  int
+
+	mutex   sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+// NewLinkCache returns a LinkCache that saves the links in the
+// store for the given TTL and keeps up to size of them in memory.
+func NewLinkCache(store Store, size int, ttl time.Duration) *LinkCache {
+
+	return &LinkCache{
+		store:   store,
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+
+}
+
+// GetLink returns the shortened referral link of a product,
+// if it was cached and it didn't expire yet.
+func (c *LinkCache) GetLink(marketplace string, asin string, tag string, options string) (shortURL string, found bool, err error) {
+
+	key := structs.LinkKey(marketplace, asin, tag, options)
+	now := time.Now().Unix()
+
+	if link, ok := c.getMemory(key); ok && link.ExpiresAt > now {
+		return link.URL, true, nil
+	}
+
+	link, found, err := c.store.GetLink(marketplace, asin, tag, options)
+	if err != nil || !found || link.ExpiresAt <= now {
+		return "", false, err
+	}
+
+	c.putMemory(link)
+	return link.URL, true, nil
+
+}
+
+// PutLink caches the shortened referral link of a product.
+// The link is kept in memory even if it can't be saved in the Store.
+func (c *LinkCache) PutLink(marketplace string, asin string, tag string, options string, shortURL string) error {
+
+	link := structs.Link{
+		LinkKey:     structs.LinkKey(marketplace, asin, tag, options),
+		Marketplace: marketplace,
+		ASIN:        asin,
+		Tag:         tag,
+		Options:     options,
+		URL:         shortURL,
+		ExpiresAt:   time.Now().Add(c.ttl).Unix(),
+	}
+
+	c.putMemory(link)
+	return c.store.PutLink(link)
+
+}
+
+// getMemory returns the link kept in memory, if any,
+// marking it as the most recently used one.
+func (c *LinkCache) getMemory(key string) (structs.Link, bool) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		return structs.Link{}, false
+	}
+
+	c.order.MoveToFront(element)
+	return element.Value.(structs.Link), true
+
+}
+
+// putMemory keeps the link in memory, evicting
+// the least recently used one if the cache is full.
+func (c *LinkCache) putMemory(link structs.Link) {
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if element, found := c.entries[link.LinkKey]; found {
+		element.Value = link
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[link.LinkKey] = c.order.PushFront(link)
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(structs.Link).LinkKey)
+	}
+
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// countingStore is a MemoryStore that counts
+// the links read from it.
+type countingStore struct {
+	*MemoryStore
+	reads int
+}
+
+func (s *countingStore) GetLink(marketplace string, asin string, tag string, options string) (structs.Link, bool, error) {
+	s.reads++
+	return s.MemoryStore.GetLink(marketplace, asin, tag, options)
+}
+
+func TestLinkCache(t *testing.T) {
+
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	cache := NewLinkCache(store, 2, time.Hour)
+
+	for _, asin := range []string{"B00TEST001", "B00TEST002", "B00TEST003"} {
+		if err := cache.PutLink("amazon.it", asin, "ref-21", "", "https://bit.ly/"+asin); err != nil {
+			t.Fatalf("PutLink() error = %v", err)
+		}
+	}
+
+	// An expired link saved by another container.
+	err := store.PutLink(structs.Link{Marketplace: "amazon.it", ASIN: "B00TEST004", Tag: "ref-21", URL: "https://bit.ly/old", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatalf("PutLink() error = %v", err)
+	}
+
+	tests := []struct {
+		asin      string
+		wantURL   string
+		wantFound bool
+		wantReads int
+	}{
+		{"B00TEST003", "https://bit.ly/B00TEST003", true, 0},
+		{"B00TEST002", "https://bit.ly/B00TEST002", true, 0},
+		{"B00TEST001", "https://bit.ly/B00TEST001", true, 1},
+		{"B00TEST001", "https://bit.ly/B00TEST001", true, 1},
+		{"B00TEST003", "https://bit.ly/B00TEST003", true, 2},
+		{"B00TEST004", "", false, 3},
+		{"B00TEST005", "", false, 4},
+	}
+
+	for _, tt := range tests {
+
+		got, found, err := cache.GetLink("amazon.it", tt.asin, "ref-21", "")
+		if err != nil || found != tt.wantFound || got != tt.wantURL {
+			t.Errorf("GetLink(%s) = %s, %v, %v, want %s, %v, nil", tt.asin, got, found, err, tt.wantURL, tt.wantFound)
+		}
+
+		if store.reads != tt.wantReads {
+			t.Errorf("GetLink(%s) read %d links from the store, want %d", tt.asin, store.reads, tt.wantReads)
+		}
+
+	}
+
+}
@@ -0,0 +1,73 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// PutLink saves on DynamoDB the shortened referral link
+// of a product, replacing the previous one.
+func (s *DynamoDBStore) PutLink(link structs.Link) error {
+
+	link.LinkKey = structs.LinkKey(link.Marketplace, link.ASIN, link.Tag, link.Options)
+	link.UnixTime = time.Now().Unix()
+
+	marshalledLink, err := dynamodbattribute.MarshalMap(link)
+	if err != nil {
+		return errors.Errorf("PutLink: error while marshaling link: %v", err)
+	}
+
+	_, err = s.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(link.Table()),
+		Item:      marshalledLink,
+	})
+
+	if err != nil {
+		return errors.Errorf("PutLink: unable to save link: %s", err)
+	}
+
+	return nil
+
+}
+
+// GetLink returns the shortened referral link of a product, if any.
+func (s *DynamoDBStore) GetLink(marketplace string, asin string, tag string, options string) (link structs.Link, found bool, err error) {
+
+	output, err := s.client.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"LinkKey": {
+				S: aws.String(structs.LinkKey(marketplace, asin, tag, options)),
+			},
+		},
+		TableName: aws.String(structs.Link{}.Table()),
+	})
+
+	if err != nil {
+		err = errors.Errorf("GetLink: error while querying the database: %s", err)
+		return
+	}
+
+	if output.Item == nil {
+		return
+	}
+
+	err = dynamodbattribute.UnmarshalMap(output.Item, &link)
+	if err != nil {
+		err = errors.Errorf("GetLink: failed to unmarshal link, %v", err)
+		return
+	}
+
+	found = true
+	return
+
+}
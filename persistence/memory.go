@@ -0,0 +1,270 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// MemoryStore is a thread-safe Store that keeps
+// users, requests, replies and links in memory. Its content is lost
+// when the application stops.
+type MemoryStore struct {
+	mutex    sync.RWMutex
+	users    map[int]structs.User
+	requests []structs.Request
+	replies  map[string]structs.Reply
+	links    map[string]structs.Link
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+// The users whose IDs are provided will be marked as admins.
+func NewMemoryStore(adminIDs ...int) *MemoryStore {
+
+	store := &MemoryStore{
+		users:   make(map[int]structs.User),
+		replies: make(map[string]structs.Reply),
+		links:   make(map[string]structs.Link),
+	}
+
+	for _, adminID := range adminIDs {
+		store.users[adminID] = structs.User{TelegramID: adminID, IsAdmin: true}
+	}
+
+	return store
+
+}
+
+// PutUser saves a user in memory or updates it if they
+// had previously blocked the bot.
+func (s *MemoryStore) PutUser(userID int) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, found := s.users[userID]
+	if !found {
+		user.FirstSeen = time.Now().Unix()
+	}
+
+	user.TelegramID = userID
+	user.HasBlockedBot = false
+	s.users[userID] = user
+
+	return nil
+
+}
+
+// UpdateUserBlockStatus updates the HasBlockedBot field according to the input flag.
+func (s *MemoryStore) UpdateUserBlockStatus(userID int, hasBlockedBot bool) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user := s.users[userID]
+	user.TelegramID = userID
+	user.HasBlockedBot = hasBlockedBot
+	s.users[userID] = user
+
+	return nil
+
+}
+
+// PutAdmin sets the user's IsAdmin field to true,
+// saving the user if they're not saved yet.
+func (s *MemoryStore) PutAdmin(userID int) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	user, found := s.users[userID]
+	if !found {
+		user.FirstSeen = time.Now().Unix()
+	}
+
+	user.TelegramID = userID
+	user.IsAdmin = true
+	s.users[userID] = user
+
+	return nil
+
+}
+
+// IsUserAdmin returns true if the user's IsAdmin field is true.
+func (s *MemoryStore) IsUserAdmin(userID int) (bool, error) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.users[userID].IsAdmin, nil
+
+}
+
+// GetAllUsers returns all the users that didn't block the bot,
+// sorted by Telegram ID.
+func (s *MemoryStore) GetAllUsers() (users []structs.User, err error) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, user := range s.users {
+		if !user.HasBlockedBot {
+			users = append(users, user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].TelegramID < users[j].TelegramID
+	})
+
+	return
+
+}
+
+// CountUsersSince returns the number of users
+// first seen after a certain threshold.
+func (s *MemoryStore) CountUsersSince(threshold int64) (count int, err error) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, user := range s.users {
+		if user.FirstSeen > threshold {
+			count++
+		}
+	}
+
+	return
+
+}
+
+// PutRequest saves a request in memory.
+func (s *MemoryStore) PutRequest(request structs.Request) error {
+
+	request = NewRequest(request)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.requests = append(s.requests, request)
+	return nil
+
+}
+
+// PutRequests saves the requests in memory.
+func (s *MemoryStore) PutRequests(requests []structs.Request) error {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, request := range requests {
+		s.requests = append(s.requests, NewRequest(request))
+	}
+
+	return nil
+
+}
+
+// GetRequestsPage returns at most limit requests selected by the
+// query, in the order they were saved, starting after the one whose
+// XID is the cursor. It also returns the cursor of the next page.
+func (s *MemoryStore) GetRequestsPage(query RequestQuery, cursor string, limit int) (requests []structs.Request, next string, err error) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	start := 0
+	if cursor != "" {
+		start = -1
+		for index, request := range s.requests {
+			if request.XID == cursor {
+				start = index + 1
+				break
+			}
+		}
+	}
+
+	if start < 0 {
+		err = errors.Errorf("GetRequestsPage: unknown cursor %s", cursor)
+		return
+	}
+
+	for _, request := range s.requests[start:] {
+
+		if !query.Matches(request) {
+			continue
+		}
+
+		// There's a next page only if it's not empty.
+		if len(requests) == limit {
+			next = requests[limit-1].XID
+			break
+		}
+
+		requests = append(requests, request)
+
+	}
+
+	return
+
+}
+
+// PutReply saves in memory the reply the bot sent to a message,
+// replacing the previous one.
+func (s *MemoryStore) PutReply(reply structs.Reply) error {
+
+	reply.MessageKey = structs.ReplyKey(reply.ChatID, reply.MessageID)
+	reply.UnixTime = time.Now().Unix()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.replies[reply.MessageKey] = reply
+	return nil
+
+}
+
+// GetReply returns the reply the bot sent to a message, if any.
+func (s *MemoryStore) GetReply(chatID int64, messageID int) (structs.Reply, bool, error) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	reply, found := s.replies[structs.ReplyKey(chatID, messageID)]
+	return reply, found, nil
+
+}
+
+// PutLink saves in memory the shortened referral
+// link of a product, replacing the previous one.
+func (s *MemoryStore) PutLink(link structs.Link) error {
+
+	link.LinkKey = structs.LinkKey(link.Marketplace, link.ASIN, link.Tag, link.Options)
+	link.UnixTime = time.Now().Unix()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.links[link.LinkKey] = link
+	return nil
+
+}
+
+// GetLink returns the shortened referral link of a product, if any.
+func (s *MemoryStore) GetLink(marketplace string, asin string, tag string, options string) (structs.Link, bool, error) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	link, found := s.links[structs.LinkKey(marketplace, asin, tag, options)]
+	return link, found, nil
+
+}
@@ -0,0 +1,355 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+func TestMemoryStore_Users(t *testing.T) {
+
+	store := NewMemoryStore(1)
+	for _, userID := range []int{3, 2, 1} {
+		if err := store.PutUser(userID); err != nil {
+			t.Fatalf("PutUser() error = %v", err)
+		}
+	}
+
+	if err := store.UpdateUserBlockStatus(2, true); err != nil {
+		t.Fatalf("UpdateUserBlockStatus() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		userID      int
+		wantIsAdmin bool
+	}{
+		{
+			name:        "Admin",
+			userID:      1,
+			wantIsAdmin: true,
+		},
+		{
+			name:        "Blocked user",
+			userID:      2,
+			wantIsAdmin: false,
+		},
+		{
+			name:        "Unknown user",
+			userID:      4,
+			wantIsAdmin: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isAdmin, err := store.IsUserAdmin(tt.userID)
+			if err != nil {
+				t.Errorf("IsUserAdmin() error = %v", err)
+				return
+			}
+			if isAdmin != tt.wantIsAdmin {
+				t.Errorf("IsUserAdmin() = %v, want %v", isAdmin, tt.wantIsAdmin)
+			}
+		})
+	}
+
+	want := []structs.User{{TelegramID: 1, IsAdmin: true}, {TelegramID: 3}}
+	users, err := store.GetAllUsers()
+	if err != nil {
+		t.Fatalf("GetAllUsers() error = %v", err)
+	}
+
+	// The admins already exist, the other users
+	// are first seen when they're saved.
+	for i := range users {
+		if (users[i].FirstSeen == 0) != users[i].IsAdmin {
+			t.Errorf("GetAllUsers()[%d] first seen at %d", i, users[i].FirstSeen)
+		}
+		users[i].FirstSeen = 0
+	}
+	if !reflect.DeepEqual(users, want) {
+		t.Errorf("GetAllUsers() = %v, want %v", users, want)
+	}
+
+	// Putting the user again must unblock them.
+	_ = store.PutUser(2)
+	users, _ = store.GetAllUsers()
+	if len(users) != 3 {
+		t.Errorf("GetAllUsers() after unblock returned %d users, want 3", len(users))
+	}
+
+	count, err := store.CountUsersSince(time.Now().Add(-time.Hour).Unix())
+	if err != nil || count != 2 {
+		t.Errorf("CountUsersSince() = %d, %v, want 2, nil", count, err)
+	}
+
+}
+
+func TestMemoryStore_PutAdmin(t *testing.T) {
+	testStorePutAdmin(t, NewMemoryStore())
+}
+
+func testStorePutAdmin(t *testing.T, store Store) {
+
+	if err := store.PutUser(1); err != nil {
+		t.Fatalf("PutUser() error = %v", err)
+	}
+
+	// Both existing and unknown users become admins.
+	for _, userID := range []int{1, 2} {
+
+		if err := store.PutAdmin(userID); err != nil {
+			t.Fatalf("PutAdmin() error = %v", err)
+		}
+
+		isAdmin, err := store.IsUserAdmin(userID)
+		if err != nil || !isAdmin {
+			t.Errorf("IsUserAdmin(%d) = %v, %v, want true, nil", userID, isAdmin, err)
+		}
+
+	}
+
+	// Putting an admin again must keep them an admin.
+	_ = store.PutUser(2)
+	if isAdmin, _ := store.IsUserAdmin(2); !isAdmin {
+		t.Error("IsUserAdmin() after PutUser = false, want true")
+	}
+
+	users, err := store.GetAllUsers()
+	if err != nil || len(users) != 2 {
+		t.Errorf("GetAllUsers() = %v, %v, want 2 users", users, err)
+	}
+
+}
+
+func TestMemoryStore_Concurrency(t *testing.T) {
+
+	store := NewMemoryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(userID int) {
+			defer wg.Done()
+			_ = store.PutUser(userID)
+			_ = store.PutRequest(structs.Request{TelegramID: userID, URL: "https://amzn.to/"})
+			_, _ = store.GetAllUsers()
+		}(i)
+	}
+	wg.Wait()
+
+	requests, _, _ := store.GetRequestsPage(RequestQuery{}, "", 100)
+	if len(requests) != 50 {
+		t.Errorf("GetRequestsPage() returned %d requests, want 50", len(requests))
+	}
+
+}
+
+func TestMemoryStore_Replies(t *testing.T) {
+	testStoreReplies(t, NewMemoryStore())
+}
+
+// testStoreReplies checks that the replies can be
+// saved, replaced and retrieved by message.
+func testStoreReplies(t *testing.T, store Store) {
+
+	_, found, err := store.GetReply(-100, 1)
+	if err != nil || found {
+		t.Fatalf("GetReply() of a missing reply = %v, %v, want false, nil", found, err)
+	}
+
+	replies := []structs.Reply{
+		{ChatID: -100, MessageID: 1, ReplyID: 2, URLs: []string{"https://amzn.to/a"}},
+		{ChatID: 100, MessageID: 1, ReplyID: 3},
+		{ChatID: -100, MessageID: 1, ReplyID: 4, URLs: []string{"https://amzn.to/a", "https://amzn.to/b"}},
+	}
+
+	for _, reply := range replies {
+		if err := store.PutReply(reply); err != nil {
+			t.Fatalf("PutReply() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		chatID    int64
+		messageID int
+		want      structs.Reply
+	}{
+		{-100, 1, replies[2]},
+		{100, 1, replies[1]},
+	}
+
+	for _, tt := range tests {
+
+		got, found, err := store.GetReply(tt.chatID, tt.messageID)
+		if err != nil || !found {
+			t.Fatalf("GetReply(%d, %d) = %v, %v, want true, nil", tt.chatID, tt.messageID, found, err)
+		}
+
+		if got.MessageKey != structs.ReplyKey(tt.chatID, tt.messageID) || got.ReplyID != tt.want.ReplyID || !reflect.DeepEqual(got.URLs, tt.want.URLs) {
+			t.Errorf("GetReply(%d, %d) = %+v, want %+v", tt.chatID, tt.messageID, got, tt.want)
+		}
+
+	}
+
+}
+
+func TestMemoryStore_Links(t *testing.T) {
+	testStoreLinks(t, NewMemoryStore())
+}
+
+// testStoreLinks checks that the links can be
+// saved, replaced and retrieved by product.
+func testStoreLinks(t *testing.T, store Store) {
+
+	_, found, err := store.GetLink("amazon.it", "B00TEST123", "ref-21", "")
+	if err != nil || found {
+		t.Fatalf("GetLink() of a missing link = %v, %v, want false, nil", found, err)
+	}
+
+	links := []structs.Link{
+		{Marketplace: "amazon.it", ASIN: "B00TEST123", Tag: "ref-21", URL: "https://bit.ly/a", ExpiresAt: 10},
+		{Marketplace: "amazon.it", ASIN: "B00TEST123", Tag: "other-21", URL: "https://bit.ly/b"},
+		{Marketplace: "amazon.it", ASIN: "B00TEST123", Tag: "ref-21", URL: "https://bit.ly/c", ExpiresAt: 20},
+		{Marketplace: "amazon.it", ASIN: "B00TEST123", Tag: "ref-21", Options: "th=1", URL: "https://bit.ly/d"},
+	}
+
+	for _, link := range links {
+		if err := store.PutLink(link); err != nil {
+			t.Fatalf("PutLink() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		tag     string
+		options string
+		want    structs.Link
+	}{
+		{"ref-21", "", links[2]},
+		{"other-21", "", links[1]},
+		{"ref-21", "th=1", links[3]},
+	}
+
+	for _, tt := range tests {
+
+		got, found, err := store.GetLink("amazon.it", "B00TEST123", tt.tag, tt.options)
+		if err != nil || !found {
+			t.Fatalf("GetLink(%s) = %v, %v, want true, nil", tt.tag, found, err)
+		}
+
+		if got.LinkKey != structs.LinkKey("amazon.it", "B00TEST123", tt.tag, tt.options) || got.URL != tt.want.URL || got.ExpiresAt != tt.want.ExpiresAt {
+			t.Errorf("GetLink(%s) = %+v, want %+v", tt.tag, got, tt.want)
+		}
+
+	}
+
+}
+
+func TestMemoryStore_GetRequestsPage(t *testing.T) {
+	testStoreRequestsPage(t, NewMemoryStore())
+}
+
+// testStoreRequestsPage checks that the requests selected
+// by a query can be read in pages, following the cursors.
+func testStoreRequestsPage(t *testing.T, store Store) {
+
+	for i := 1; i <= 7; i++ {
+		marketplace := "amazon.it"
+		if i%2 == 0 {
+			marketplace = "amazon.de"
+		}
+		request := structs.Request{XID: fmt.Sprintf("request%d", i), TelegramID: i, Marketplace: marketplace, ASIN: fmt.Sprintf("B00TEST00%d", i%3), Time: time.Unix(int64(i*100), 0)}
+		if err := store.PutRequest(request); err != nil {
+			t.Fatalf("PutRequest() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     RequestQuery
+		limit     int
+		wantPages [][]int
+	}{
+		{"all", RequestQuery{}, 3, [][]int{{1, 2, 3}, {4, 5, 6}, {7}}},
+		{"exact pages", RequestQuery{Since: 100}, 3, [][]int{{2, 3, 4}, {5, 6, 7}}},
+		{"since", RequestQuery{Since: 450}, 10, [][]int{{5, 6, 7}}},
+		{"none", RequestQuery{Since: 700}, 10, [][]int{nil}},
+		{"user", RequestQuery{TelegramID: 4}, 3, [][]int{{4}}},
+		{"marketplace", RequestQuery{Marketplace: "amazon.de"}, 2, [][]int{{2, 4}, {6}}},
+		{"ASIN and since", RequestQuery{Since: 100, ASIN: "B00TEST001"}, 3, [][]int{{4, 7}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var gotPages [][]int
+			cursor := ""
+			for page := 0; page < 10; page++ {
+
+				requests, next, err := store.GetRequestsPage(tt.query, cursor, tt.limit)
+				if err != nil {
+					t.Fatalf("GetRequestsPage() error = %v", err)
+				}
+
+				var ids []int
+				for _, request := range requests {
+					ids = append(ids, request.TelegramID)
+				}
+				gotPages = append(gotPages, ids)
+
+				if next == "" {
+					break
+				}
+				cursor = next
+
+			}
+
+			if !reflect.DeepEqual(gotPages, tt.wantPages) {
+				t.Errorf("GetRequestsPage() pages = %v, want %v", gotPages, tt.wantPages)
+			}
+
+		})
+	}
+
+	if _, _, err := store.GetRequestsPage(RequestQuery{}, "missing", 3); err == nil {
+		t.Error("GetRequestsPage() with an unknown cursor returned no error")
+	}
+
+}
+
+func TestMemoryStore_PutRequests(t *testing.T) {
+	testStorePutRequests(t, NewMemoryStore())
+}
+
+// testStorePutRequests checks that the requests saved in
+// batches get the missing XIDs and can be read back.
+func testStorePutRequests(t *testing.T, store Store) {
+
+	requests := []structs.Request{
+		{XID: "old1", TelegramID: 1, URL: "https://amzn.to/a", Time: time.Unix(100, 0)},
+		{TelegramID: 2, URL: "https://amzn.to/b", Time: time.Unix(200, 0)},
+	}
+
+	if err := store.PutRequests(requests); err != nil {
+		t.Fatalf("PutRequests() error = %v", err)
+	}
+
+	got, _, err := store.GetRequestsPage(RequestQuery{}, "", 10)
+	if err != nil || len(got) != 2 {
+		t.Fatalf("GetRequestsPage() = %+v, %v, want 2 requests", got, err)
+	}
+
+	if got[0].XID != "old1" || got[0].UnixTime != 100 || got[1].XID == "" || got[1].UnixTime != 200 {
+		t.Errorf("GetRequestsPage() = %+v, want old1 and a new XID", got)
+	}
+
+}
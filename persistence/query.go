@@ -0,0 +1,63 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// RequestQuery selects the requests to retrieve.
+// The empty filters select all the requests.
+type RequestQuery struct {
+	// Since is the Unix time after which the requests happened.
+	Since int64
+	// TelegramID is the ID of the user that made the requests.
+	TelegramID int
+	// ASIN is the ASIN of the requested product.
+	ASIN string
+	// Marketplace is the marketplace of the requested product.
+	Marketplace string
+}
+
+// Matches returns true if the request is selected by the query.
+func (q RequestQuery) Matches(request structs.Request) bool {
+
+	return request.UnixTime > q.Since &&
+		(q.TelegramID == 0 || request.TelegramID == q.TelegramID) &&
+		(q.ASIN == "" || request.ASIN == q.ASIN) &&
+		(q.Marketplace == "" || request.Marketplace == q.Marketplace)
+
+}
+
+// ForEachRequest calls fn on every request selected by the query,
+// reading them from the store a page of the given size at a time,
+// so that they are never all in memory. It stops at the first error.
+func ForEachRequest(store Store, query RequestQuery, pageSize int, fn func(structs.Request) error) error {
+
+	cursor := ""
+	for {
+
+		requests, next, err := store.GetRequestsPage(query, cursor, pageSize)
+		if err != nil {
+			return errors.Errorf("ForEachRequest: unable to retrieve requests: %s", err)
+		}
+
+		for _, request := range requests {
+			err = fn(request)
+			if err != nil {
+				return err
+			}
+		}
+
+		if next == "" {
+			return nil
+		}
+		cursor = next
+
+	}
+
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+func TestForEachRequest(t *testing.T) {
+
+	store := NewMemoryStore(1)
+	for i := 1; i <= 5; i++ {
+		request := structs.Request{XID: fmt.Sprintf("request%d", i), TelegramID: i % 2, Time: time.Unix(int64(i*100), 0)}
+		if err := store.PutRequest(request); err != nil {
+			t.Fatalf("PutRequest() error = %v", err)
+		}
+	}
+
+	var got []string
+	err := ForEachRequest(store, RequestQuery{TelegramID: 1}, 2, func(request structs.Request) error {
+		got = append(got, request.XID)
+		return nil
+	})
+
+	want := []string{"request1", "request3", "request5"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ForEachRequest() visited %v, %v, want %v, nil", got, err, want)
+	}
+
+	calls := 0
+	stop := errors.New("stop")
+	err = ForEachRequest(store, RequestQuery{}, 2, func(request structs.Request) error {
+		calls++
+		return stop
+	})
+
+	if err != stop || calls != 1 {
+		t.Errorf("ForEachRequest() = %v after %d calls, want %v after 1", err, calls, stop)
+	}
+
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// PutReply saves on DynamoDB the reply the bot sent to a message,
+// replacing the previous one. Without a table for the replies,
+// they are not saved.
+func (s *DynamoDBStore) PutReply(reply structs.Reply) error {
+
+	if reply.Table() == "" {
+		return nil
+	}
+
+	reply.MessageKey = structs.ReplyKey(reply.ChatID, reply.MessageID)
+	reply.UnixTime = time.Now().Unix()
+
+	marshalledReply, err := dynamodbattribute.MarshalMap(reply)
+	if err != nil {
+		return errors.Errorf("PutReply: error while marshaling reply: %v", err)
+	}
+
+	_, err = s.client.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(reply.Table()),
+		Item:      marshalledReply,
+	})
+
+	if err != nil {
+		return errors.Errorf("PutReply: unable to save reply: %s", err)
+	}
+
+	return nil
+
+}
+
+// GetReply returns the reply the bot sent to a message, if any.
+// Without a table for the replies, no reply is ever found.
+func (s *DynamoDBStore) GetReply(chatID int64, messageID int) (reply structs.Reply, found bool, err error) {
+
+	if reply.Table() == "" {
+		return
+	}
+
+	output, err := s.client.GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"MessageKey": {
+				S: aws.String(structs.ReplyKey(chatID, messageID)),
+			},
+		},
+		TableName: aws.String(structs.Reply{}.Table()),
+	})
+
+	if err != nil {
+		err = errors.Errorf("GetReply: error while querying the database: %s", err)
+		return
+	}
+
+	if output.Item == nil {
+		return
+	}
+
+	err = dynamodbattribute.UnmarshalMap(output.Item, &reply)
+	if err != nil {
+		err = errors.Errorf("GetReply: failed to unmarshal reply, %v", err)
+		return
+	}
+
+	found = true
+	return
+
+}
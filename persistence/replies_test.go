@@ -0,0 +1,31 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"os"
+	"testing"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+func TestDynamoDBStore_RepliesWithoutTable(t *testing.T) {
+
+	if table, ok := os.LookupEnv("REPLY_TABLE_NAME"); ok {
+		_ = os.Unsetenv("REPLY_TABLE_NAME")
+		defer os.Setenv("REPLY_TABLE_NAME", table)
+	}
+
+	// Without a client, any request to DynamoDB would panic.
+	store := NewDynamoDBStore(nil)
+	if err := store.PutReply(structs.Reply{ChatID: 1, MessageID: 2, ReplyID: 3}); err != nil {
+		t.Errorf("PutReply() error = %v, want nil", err)
+	}
+
+	if _, found, err := store.GetReply(1, 2); found || err != nil {
+		t.Errorf("GetReply() = %v, %v, want not found", found, err)
+	}
+
+}
@@ -3,7 +3,7 @@
 // license that can be found in the LICENSE file.
 
 // Package persistence contains methods to store and retrieve
-// content from Amazon DynamoDB.
+// content from Amazon DynamoDB or from memory.
 package persistence
 
 import (
@@ -14,21 +14,34 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/expression"
 	"github.com/pkg/errors"
-	"github.com/rs/xid"
 
 	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
 )
 
-// GetRequestsSince returns the request that happened after a certain threshold.
-// The threshold is given in Unix timestamp format.
-func GetRequestsSince(threshold int64, client *dynamodb.DynamoDB) (requests []structs.Request, err error) {
+const (
+	// batchWriteSize is the maximum number of
+	// items DynamoDB accepts in a BatchWriteItem.
+	batchWriteSize = 25
+	// batchWriteRetries is the number of times the
+	// items DynamoDB didn't process are written again.
+	batchWriteRetries = 5
+	// batchWriteBackoff is the wait before writing the
+	// unprocessed items again, doubled at every retry.
+	batchWriteBackoff = 100 * time.Millisecond
+	// requestsScanLimit is the minimum number of items read
+	// by each Scan of GetRequestsPage, before the filter.
+	requestsScanLimit = 100
+)
 
-	filter := expression.Name("UnixTime").GreaterThan(expression.Value(threshold))
-	projection := expression.NamesList(expression.Name("TelegramID"), expression.Name("Time"), expression.Name("URL"))
+// GetRequestsPage returns at most limit requests selected by the
+// query, in no particular order, starting after the one whose XID
+// is the cursor. It also returns the cursor of the next page: the
+// XID of the last request returned, if the page is full.
+func (s *DynamoDBStore) GetRequestsPage(query RequestQuery, cursor string, limit int) (requests []structs.Request, next string, err error) {
 
-	expr, err := expression.NewBuilder().WithFilter(filter).WithProjection(projection).Build()
+	expr, err := expression.NewBuilder().WithFilter(requestFilter(query)).Build()
 	if err != nil {
-		err = errors.Errorf("GetRequestsSince: error while building the expression: %s", err)
+		err = errors.Errorf("GetRequestsPage: error while building the expression: %s", err)
 		return
 	}
 
@@ -36,42 +49,97 @@ func GetRequestsSince(threshold int64, client *dynamodb.DynamoDB) (requests []st
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
 		FilterExpression:          expr.Filter(),
-		ProjectionExpression:      expr.Projection(),
 		TableName:                 aws.String(structs.Request{}.Table()),
 	}
 
-	result, err := client.Scan(params)
-	if err != nil {
-		err = errors.Errorf("GetRequestsSince: error while querying the database: %s", err)
-		return
+	params.Limit = aws.Int64(requestsScanLimit)
+	if limit > requestsScanLimit {
+		params.Limit = aws.Int64(int64(limit))
 	}
 
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &requests)
-	if err != nil {
-		err = errors.Errorf("GetRequestsSince: error while unmarshaling the results: %s", err)
+	if cursor != "" {
+		params.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+			"XID": {
+				S: aws.String(cursor),
+			},
+		}
 	}
 
-	return
+	// The limit of a Scan applies before the filter, so filling
+	// a page may take more than one, and the last one may return
+	// more requests than needed: the page ends at the last one
+	// returned, whose XID is the start key of the next page.
+	for {
+
+		result, scanErr := s.client.Scan(params)
+		if scanErr != nil {
+			err = errors.Errorf("GetRequestsPage: error while querying the database: %s", scanErr)
+			return
+		}
+
+		var page []structs.Request
+		err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &page)
+		if err != nil {
+			err = errors.Errorf("GetRequestsPage: error while unmarshaling the results: %s", err)
+			return
+		}
+
+		for index, request := range page {
+
+			requests = append(requests, request)
+			if len(requests) < limit {
+				continue
+			}
+
+			if index < len(page)-1 || result.LastEvaluatedKey != nil {
+				next = request.XID
+			}
+			return
+
+		}
+
+		if result.LastEvaluatedKey == nil {
+			return
+		}
+
+		params.ExclusiveStartKey = result.LastEvaluatedKey
+
+	}
 
 }
 
-// PutRequest saves a request on DynamoDB.
-func PutRequest(userID int, url string, client *dynamodb.DynamoDB) error {
-
-	request := structs.Request{
-		XID:        xid.New().String(),
-		TelegramID: userID,
-		URL:        url,
-		Time:       time.Now(),
-		UnixTime:   time.Now().Unix(),
+// requestFilter returns the DynamoDB filter of the query.
+func requestFilter(query RequestQuery) expression.ConditionBuilder {
+
+	filter := expression.Name("UnixTime").GreaterThan(expression.Value(query.Since))
+
+	if query.TelegramID != 0 {
+		filter = filter.And(expression.Name("TelegramID").Equal(expression.Value(query.TelegramID)))
+	}
+
+	if query.ASIN != "" {
+		filter = filter.And(expression.Name("ASIN").Equal(expression.Value(query.ASIN)))
+	}
+
+	if query.Marketplace != "" {
+		filter = filter.And(expression.Name("Marketplace").Equal(expression.Value(query.Marketplace)))
 	}
 
+	return filter
+
+}
+
+// PutRequest saves a request on DynamoDB.
+func (s *DynamoDBStore) PutRequest(request structs.Request) error {
+
+	request = NewRequest(request)
+
 	marshalledRequest, err := dynamodbattribute.MarshalMap(request)
 	if err != nil {
 		return errors.Errorf("PutRequest: error while marshaling request: %v", err)
 	}
 
-	_, err = client.PutItem(&dynamodb.PutItemInput{
+	_, err = s.client.PutItem(&dynamodb.PutItemInput{
 		TableName: aws.String(request.Table()),
 		Item:      marshalledRequest,
 	})
@@ -79,3 +147,79 @@ func PutRequest(userID int, url string, client *dynamodb.DynamoDB) error {
 	return err
 
 }
+
+// PutRequests saves the requests with BatchWriteItem, in batches of
+// 25. The items DynamoDB doesn't process, e.g. because the table is
+// throttled, are written again a few times, waiting in between.
+func (s *DynamoDBStore) PutRequests(requests []structs.Request) error {
+
+	table := structs.Request{}.Table()
+	for start := 0; start < len(requests); start += batchWriteSize {
+
+		end := start + batchWriteSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		writes := make([]*dynamodb.WriteRequest, 0, end-start)
+		for _, request := range requests[start:end] {
+
+			marshalledRequest, err := dynamodbattribute.MarshalMap(NewRequest(request))
+			if err != nil {
+				return errors.Errorf("PutRequests: error while marshaling request: %v", err)
+			}
+
+			writes = append(writes, &dynamodb.WriteRequest{
+				PutRequest: &dynamodb.PutRequest{Item: marshalledRequest},
+			})
+
+		}
+
+		err := s.batchWrite(map[string][]*dynamodb.WriteRequest{table: writes})
+		if err != nil {
+			return errors.Errorf("PutRequests: unable to save requests %d to %d: %s", start, end-1, err)
+		}
+
+	}
+
+	return nil
+
+}
+
+// batchWrite writes the items, retrying the unprocessed ones.
+func (s *DynamoDBStore) batchWrite(items map[string][]*dynamodb.WriteRequest) error {
+
+	backoff := batchWriteBackoff
+	for attempt := 0; ; attempt++ {
+
+		result, err := s.client.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: items})
+		if err != nil {
+			return err
+		}
+
+		items = result.UnprocessedItems
+		if len(items) == 0 {
+			return nil
+		}
+
+		if attempt == batchWriteRetries {
+			return errors.Errorf("%d items still unprocessed after %d retries", countWrites(items), batchWriteRetries)
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+
+	}
+
+}
+
+// countWrites returns the number of write requests in the items.
+func countWrites(items map[string][]*dynamodb.WriteRequest) (count int) {
+
+	for _, writes := range items {
+		count += len(writes)
+	}
+
+	return
+
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+const (
+	// statsDayFormat is the format of the days in the statistics.
+	statsDayFormat = "2006-01-02"
+	// statsPageSize is the number of requests read
+	// from the store at a time to compute the statistics.
+	statsPageSize = 1000
+)
+
+// GetRequestStats returns the statistics of the requests made
+// since the given time, with at most top ASINs and marketplaces.
+// Only the requests of the period are read, a page at a time.
+// The new users are the ones first seen in the period.
+func GetRequestStats(store Store, since time.Time, top int) (stats structs.RequestStats, err error) {
+
+	now := time.Now()
+	aggregator := newStatsAggregator(since, now)
+
+	// The query selects the requests made after the threshold,
+	// so the one of the second before includes those made at since.
+	query := RequestQuery{Since: since.Unix() - 1}
+	err = ForEachRequest(store, query, statsPageSize, func(request structs.Request) error {
+		aggregator.add(request)
+		return nil
+	})
+
+	if err != nil {
+		err = errors.Errorf("GetRequestStats: unable to retrieve requests: %s", err)
+		return
+	}
+
+	stats = aggregator.stats(top)
+	stats.NewUsers, err = store.CountUsersSince(since.Unix() - 1)
+	if err != nil {
+		err = errors.Errorf("GetRequestStats: unable to count the new users: %s", err)
+	}
+
+	return
+
+}
+
+// statsAggregator counts the requests made between since
+// and now, one at a time, so that they are never all in memory.
+type statsAggregator struct {
+	since        time.Time
+	now          time.Time
+	requests     int
+	users        map[int]bool
+	asins        map[string]int
+	marketplaces map[string]int
+	days         map[string]int
+}
+
+// newStatsAggregator returns an empty statsAggregator for the period.
+func newStatsAggregator(since time.Time, now time.Time) *statsAggregator {
+
+	return &statsAggregator{
+		since:        since,
+		now:          now,
+		users:        make(map[int]bool),
+		asins:        make(map[string]int),
+		marketplaces: make(map[string]int),
+		days:         make(map[string]int),
+	}
+
+}
+
+// add counts the request, if it was made in the period. The requests
+// saved before the ASIN and the marketplace were recorded are only
+// counted in the totals, not in the top products and marketplaces.
+func (a *statsAggregator) add(request structs.Request) {
+
+	if request.Time.Before(a.since) || request.Time.After(a.now) {
+		return
+	}
+
+	a.requests++
+	a.users[request.TelegramID] = true
+	a.days[request.Time.UTC().Format(statsDayFormat)]++
+
+	if request.ASIN != "" {
+		a.asins[request.ASIN]++
+	}
+
+	if request.Marketplace != "" {
+		a.marketplaces[request.Marketplace]++
+	}
+
+}
+
+// stats returns the statistics of the requests
+// counted so far, with at most top ASINs and marketplaces.
+func (a *statsAggregator) stats(top int) structs.RequestStats {
+
+	stats := structs.RequestStats{
+		Since:           a.since,
+		Requests:        a.requests,
+		Users:           len(a.users),
+		TopASINs:        topCounts(a.asins, top),
+		TopMarketplaces: topCounts(a.marketplaces, top),
+	}
+
+	for day := a.since.UTC().Truncate(24 * time.Hour); !day.After(a.now); day = day.Add(24 * time.Hour) {
+		key := day.Format(statsDayFormat)
+		stats.Days = append(stats.Days, structs.Count{Key: key, Requests: a.days[key]})
+	}
+
+	return stats
+
+}
+
+// topCounts returns at most top counts, sorted by
+// number of requests and then by key.
+func topCounts(counts map[string]int, top int) []structs.Count {
+
+	sorted := make([]structs.Count, 0, len(counts))
+	for key, requests := range counts {
+		sorted = append(sorted, structs.Count{Key: key, Requests: requests})
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Requests != sorted[j].Requests {
+			return sorted[i].Requests > sorted[j].Requests
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	if len(sorted) > top {
+		sorted = sorted[:top]
+	}
+
+	return sorted
+
+}
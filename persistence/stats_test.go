@@ -0,0 +1,111 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+func Test_statsAggregator(t *testing.T) {
+
+	now := time.Date(2019, 12, 31, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-48 * time.Hour)
+	at := func(hoursAgo int) time.Time {
+		return now.Add(-time.Duration(hoursAgo) * time.Hour)
+	}
+
+	requests := []structs.Request{
+		{TelegramID: 1, Marketplace: "amazon.it", ASIN: "B00TEST001", Time: at(100)},
+		{TelegramID: 1, Marketplace: "amazon.it", ASIN: "B00TEST001", Time: at(40)},
+		{TelegramID: 2, Marketplace: "amazon.it", ASIN: "B00TEST002", Time: at(30)},
+		{TelegramID: 2, Marketplace: "amazon.de", ASIN: "B00TEST002", Time: at(2)},
+		{TelegramID: 3, Marketplace: "amazon.de", ASIN: "B00TEST003", Time: at(1)},
+		{TelegramID: 3, URL: "https://amzn.to/old", Time: at(1)},
+	}
+
+	tests := []struct {
+		name string
+		top  int
+		want structs.RequestStats
+	}{
+		{
+			name: "Top 2",
+			top:  2,
+			want: structs.RequestStats{
+				Since:           since,
+				Requests:        5,
+				Users:           3,
+				TopASINs:        []structs.Count{{Key: "B00TEST002", Requests: 2}, {Key: "B00TEST001", Requests: 1}},
+				TopMarketplaces: []structs.Count{{Key: "amazon.de", Requests: 2}, {Key: "amazon.it", Requests: 2}},
+				Days: []structs.Count{
+					{Key: "2019-12-29", Requests: 1},
+					{Key: "2019-12-30", Requests: 1},
+					{Key: "2019-12-31", Requests: 3},
+				},
+			},
+		},
+		{
+			name: "Top 0",
+			top:  0,
+			want: structs.RequestStats{
+				Since:           since,
+				Requests:        5,
+				Users:           3,
+				TopASINs:        []structs.Count{},
+				TopMarketplaces: []structs.Count{},
+				Days: []structs.Count{
+					{Key: "2019-12-29", Requests: 1},
+					{Key: "2019-12-30", Requests: 1},
+					{Key: "2019-12-31", Requests: 3},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			aggregator := newStatsAggregator(since, now)
+			for _, request := range requests {
+				aggregator.add(request)
+			}
+
+			if got := aggregator.stats(tt.top); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stats() = %+v, want %+v", got, tt.want)
+			}
+
+		})
+	}
+
+}
+
+func TestGetRequestStats(t *testing.T) {
+
+	store := NewMemoryStore()
+	store.users[1] = structs.User{TelegramID: 1, FirstSeen: time.Now().Add(-240 * time.Hour).Unix()}
+	if err := store.PutUser(2); err != nil {
+		t.Fatalf("PutUser() error = %v", err)
+	}
+
+	for _, request := range []structs.Request{
+		{TelegramID: 1, ASIN: "B00TEST001", Time: time.Now().Add(-time.Hour)},
+		{TelegramID: 1, ASIN: "B00TEST001", Time: time.Now().Add(-240 * time.Hour)},
+		{TelegramID: 2, ASIN: "B00TEST002", Time: time.Now().Add(-time.Hour)},
+	} {
+		if err := store.PutRequest(request); err != nil {
+			t.Fatalf("PutRequest() error = %v", err)
+		}
+	}
+
+	stats, err := GetRequestStats(store, time.Now().Add(-24*time.Hour), 5)
+	if err != nil || stats.Requests != 2 || stats.Users != 2 || stats.NewUsers != 1 {
+		t.Errorf("GetRequestStats() = %+v, %v, want 2 requests of 2 users, 1 new", stats, err)
+	}
+
+}
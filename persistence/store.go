@@ -0,0 +1,117 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package persistence
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/rs/xid"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// Store is the storage for users, requests, replies and links.
+// Implementations must be safe for concurrent use.
+type Store interface {
+
+	// PutUser saves a user or updates it if they
+	// had previously blocked the bot.
+	PutUser(userID int) error
+
+	// UpdateUserBlockStatus updates the HasBlockedBot
+	// field according to the input flag.
+	UpdateUserBlockStatus(userID int, hasBlockedBot bool) error
+
+	// IsUserAdmin returns true if the user's IsAdmin field is true.
+	IsUserAdmin(userID int) (bool, error)
+
+	// PutAdmin sets the user's IsAdmin field to true,
+	// saving the user if they're not saved yet.
+	PutAdmin(userID int) error
+
+	// GetAllUsers returns all the users that didn't block the bot.
+	GetAllUsers() ([]structs.User, error)
+
+	// CountUsersSince returns the number of users first seen after a
+	// certain threshold, given in Unix timestamp format. The users
+	// saved before their first-seen time was recorded are not counted.
+	CountUsersSince(threshold int64) (int, error)
+
+	// PutRequest saves a request. The missing XID
+	// and time are filled in with NewRequest.
+	PutRequest(request structs.Request) error
+
+	// PutRequests saves the requests in batches, like PutRequest
+	// does for each of them. A failed batch may be partially saved.
+	PutRequests(requests []structs.Request) error
+
+	// GetRequestsPage returns at most limit requests selected by the
+	// query, starting after the one whose XID is the cursor, or from
+	// the first one if the cursor is empty. It also returns the cursor
+	// of the next page, empty if there are no more requests.
+	GetRequestsPage(query RequestQuery, cursor string, limit int) (requests []structs.Request, next string, err error)
+
+	// PutReply saves the reply the bot sent to a message,
+	// replacing the previous one.
+	PutReply(reply structs.Reply) error
+
+	// GetReply returns the reply the bot sent to a message, if any.
+	GetReply(chatID int64, messageID int) (reply structs.Reply, found bool, err error)
+
+	// PutLink saves the shortened referral link
+	// of a product, replacing the previous one.
+	PutLink(link structs.Link) error
+
+	// GetLink returns the shortened referral link of a product, if
+	// any. Expired links may still be returned: the callers must
+	// check their ExpiresAt field.
+	GetLink(marketplace string, asin string, tag string, options string) (link structs.Link, found bool, err error)
+}
+
+// PutUserRequests saves the user and the requests they made.
+// It tries to save all of them, returning the first error.
+func PutUserRequests(store Store, userID int, requests []structs.Request) (err error) {
+
+	err = store.PutUser(userID)
+
+	for _, request := range requests {
+		request.TelegramID = userID
+		if putErr := store.PutRequest(request); putErr != nil && err == nil {
+			err = putErr
+		}
+	}
+
+	return
+
+}
+
+// NewRequest returns the request with a new XID, if missing, and
+// the current time, if not set. UnixTime is always set from Time.
+func NewRequest(request structs.Request) structs.Request {
+
+	if request.XID == "" {
+		request.XID = xid.New().String()
+	}
+
+	if request.Time.IsZero() {
+		request.Time = time.Now()
+	}
+
+	request.UnixTime = request.Time.Unix()
+	return request
+
+}
+
+// DynamoDBStore is a Store backed by Amazon DynamoDB.
+// The table names are read from the environment variables.
+type DynamoDBStore struct {
+	client *dynamodb.DynamoDB
+}
+
+// NewDynamoDBStore returns a Store that uses the provided DynamoDB client.
+func NewDynamoDBStore(client *dynamodb.DynamoDB) *DynamoDBStore {
+	return &DynamoDBStore{client: client}
+}
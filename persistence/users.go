@@ -6,6 +6,7 @@ package persistence
 
 import (
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -18,7 +19,7 @@ import (
 )
 
 // GetAllUsers returns all the users that didn't block the bot.
-func GetAllUsers(client *dynamodb.DynamoDB) (users []structs.User, err error) {
+func (s *DynamoDBStore) GetAllUsers() (users []structs.User, err error) {
 
 	filter := expression.Name("HasBlockedBot").Equal(expression.Value(false))
 	projection := expression.NamesList(expression.Name("TelegramID"))
@@ -38,7 +39,7 @@ func GetAllUsers(client *dynamodb.DynamoDB) (users []structs.User, err error) {
 	}
 
 	// Make the DynamoDB Query API call
-	result, err := client.Scan(params)
+	result, err := s.client.Scan(params)
 	if err != nil {
 		err = errors.Errorf("GetAllUsers: error while querying the database: %s", err)
 		return
@@ -54,13 +55,46 @@ func GetAllUsers(client *dynamodb.DynamoDB) (users []structs.User, err error) {
 
 }
 
+// CountUsersSince returns the number of users first seen after a
+// certain threshold, scanning the table as many times as needed.
+func (s *DynamoDBStore) CountUsersSince(threshold int64) (count int, err error) {
+
+	filter := expression.Name("FirstSeen").GreaterThan(expression.Value(threshold))
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		err = errors.Errorf("CountUsersSince: error while building the expression: %s", err)
+		return
+	}
+
+	params := &dynamodb.ScanInput{
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		FilterExpression:          expr.Filter(),
+		Select:                    aws.String(dynamodb.SelectCount),
+		TableName:                 aws.String(structs.User{}.Table()),
+	}
+
+	err = s.client.ScanPages(params, func(page *dynamodb.ScanOutput, _ bool) bool {
+		count += int(aws.Int64Value(page.Count))
+		return true
+	})
+
+	if err != nil {
+		err = errors.Errorf("CountUsersSince: error while querying the database: %s", err)
+	}
+
+	return
+
+}
+
 // PutUser saves a user on DynamoDB or updates it if they
 // had previously blocked the bot.
-func PutUser(userID int, client *dynamodb.DynamoDB) error {
+func (s *DynamoDBStore) PutUser(userID int) error {
 
 	user := structs.User{
 		TelegramID:    userID,
 		HasBlockedBot: false,
+		FirstSeen:     time.Now().Unix(),
 	}
 
 	marshalledUser, err := dynamodbattribute.MarshalMap(user)
@@ -69,7 +103,7 @@ func PutUser(userID int, client *dynamodb.DynamoDB) error {
 	}
 
 	//Add the user only if the telegramID does not already exist.
-	_, err = client.PutItem(&dynamodb.PutItemInput{
+	_, err = s.client.PutItem(&dynamodb.PutItemInput{
 		ConditionExpression: aws.String("attribute_not_exists(TelegramID)"),
 		TableName:           aws.String(user.Table()),
 		Item:                marshalledUser,
@@ -83,7 +117,7 @@ func PutUser(userID int, client *dynamodb.DynamoDB) error {
 			// Primary key condition failed.
 			// Update the user to make sure HasBlockedUser is not true.
 			if aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
-				_ = UpdateUserBlockStatus(userID, false, client)
+				return s.UpdateUserBlockStatus(userID, false)
 			}
 
 		}
@@ -94,7 +128,7 @@ func PutUser(userID int, client *dynamodb.DynamoDB) error {
 }
 
 // UpdateUserBlockStatus updates the HasBlockedUser field according to the input flag.
-func UpdateUserBlockStatus(userID int, hasBlockedBot bool, client *dynamodb.DynamoDB) (err error) {
+func (s *DynamoDBStore) UpdateUserBlockStatus(userID int, hasBlockedBot bool) (err error) {
 
 	input := &dynamodb.UpdateItemInput{
 		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
@@ -111,7 +145,7 @@ func UpdateUserBlockStatus(userID int, hasBlockedBot bool, client *dynamodb.Dyna
 		UpdateExpression: aws.String("set HasBlockedBot = :b"),
 	}
 
-	_, err = client.UpdateItem(input)
+	_, err = s.client.UpdateItem(input)
 	if err != nil {
 		err = errors.Errorf("UpdateUserBlockStatus: unable to update user status: %s", err)
 	}
@@ -120,10 +154,38 @@ func UpdateUserBlockStatus(userID int, hasBlockedBot bool, client *dynamodb.Dyna
 
 }
 
+// PutAdmin sets the user's IsAdmin field to true,
+// saving the user if they're not saved yet.
+func (s *DynamoDBStore) PutAdmin(userID int) (err error) {
+
+	input := &dynamodb.UpdateItemInput{
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":a": {
+				BOOL: aws.Bool(true),
+			},
+		},
+		TableName: aws.String(structs.User{}.Table()),
+		Key: map[string]*dynamodb.AttributeValue{
+			"TelegramID": {
+				N: aws.String(strconv.Itoa(userID)),
+			},
+		},
+		UpdateExpression: aws.String("set IsAdmin = :a"),
+	}
+
+	_, err = s.client.UpdateItem(input)
+	if err != nil {
+		err = errors.Errorf("PutAdmin: unable to save admin: %s", err)
+	}
+
+	return
+
+}
+
 // IsUserAdmin returns true if the user's IsAdmin field is true.
-func IsUserAdmin(userID int, client *dynamodb.DynamoDB) (isAdmin bool, err error) {
+func (s *DynamoDBStore) IsUserAdmin(userID int) (isAdmin bool, err error) {
 
-	output, err := client.GetItem(&dynamodb.GetItemInput{
+	output, err := s.client.GetItem(&dynamodb.GetItemInput{
 		AttributesToGet: aws.StringSlice([]string{"IsAdmin"}),
 		Key: map[string]*dynamodb.AttributeValue{
 			"TelegramID": {
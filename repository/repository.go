@@ -8,10 +8,15 @@ package repository
 
 import (
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/networks"
 )
 
 const (
@@ -19,6 +24,94 @@ const (
 	bAPIKeyName    = "BITLY_KEY"
 	refIDKeyName   = "REF_ID"
 	amazonDomain   = "AMAZON_DOMAIN"
+	refIDsKeyName  = "REF_IDS"
+
+	storageBackendKeyName = "STORAGE_BACKEND"
+	boltDBPathKeyName     = "BOLT_DB_PATH"
+	adminIDsKeyName       = "ADMIN_IDS"
+
+	runModeKeyName        = "RUN_MODE"
+	webhookAddressKeyName = "WEBHOOK_ADDRESS"
+	webhookPathKeyName    = "WEBHOOK_PATH"
+	webhookReplyKeyName   = "WEBHOOK_REPLY"
+	lambdaEventKeyName    = "LAMBDA_EVENT"
+
+	webhookSecretTokenKeyName    = "WEBHOOK_SECRET_TOKEN"
+	webhookCheckSourceIPKeyName  = "WEBHOOK_CHECK_SOURCE_IP"
+	webhookTrustedProxiesKeyName = "WEBHOOK_TRUSTED_PROXIES"
+
+	shortenerKeyName        = "SHORTENER"
+	shortenerURLKeyName     = "SHORTENER_URL"
+	shortenerKeyKeyName     = "SHORTENER_KEY"
+	bitlyDomainKeyName      = "BITLY_DOMAIN"
+	bitlyGroupGUIDKeyName   = "BITLY_GROUP_GUID"
+	shortLinkHostsKeyName   = "SHORT_LINK_HOSTS"
+	shortenerRetriesKeyName = "SHORTENER_RETRIES"
+
+	inlineCacheTimeKeyName = "INLINE_CACHE_TIME"
+	groupRepostKeyName     = "GROUP_REPOST"
+	replyModeKeyName       = "REPLY_MODE"
+
+	paapiAccessKeyKeyName = "PAAPI_ACCESS_KEY"
+	paapiSecretKeyKeyName = "PAAPI_SECRET_KEY"
+
+	linkWorkersKeyName = "LINK_WORKERS"
+	linkTimeoutKeyName = "LINK_TIMEOUT"
+
+	linkCacheTTLKeyName  = "LINK_CACHE_TTL"
+	linkCacheSizeKeyName = "LINK_CACHE_SIZE"
+)
+
+// Supported URL shorteners.
+const (
+	//ShortenerBitly shortens the links with Bitly.
+	ShortenerBitly = "bitly"
+	//ShortenerYOURLS shortens the links with a YOURLS instance.
+	ShortenerYOURLS = "yourls"
+	//ShortenerKutt shortens the links with a Kutt instance.
+	ShortenerKutt = "kutt"
+	//ShortenerNone doesn't shorten the links.
+	ShortenerNone = "none"
+)
+
+// Supported reply modes.
+const (
+	//ReplyModeList replies with the list of the referral links.
+	ReplyModeList = "list"
+	//ReplyModeRewrite replies with the original text, with the
+	//links replaced by their referral version.
+	ReplyModeRewrite = "rewrite"
+)
+
+// Supported run modes.
+const (
+	//RunModeLambda handles the updates sent by API Gateway to AWS Lambda.
+	RunModeLambda = "lambda"
+	//RunModePolling fetches the updates with getUpdates long polling.
+	RunModePolling = "polling"
+	//RunModeWebhook handles the updates with a standalone HTTP server.
+	RunModeWebhook = "webhook"
+)
+
+// Supported Lambda events.
+const (
+	//LambdaEventUpdate is the Telegram update itself, sent by
+	//API Gateway without the Lambda Proxy integration.
+	LambdaEventUpdate = "update"
+	//LambdaEventProxy is the REST API Lambda Proxy integration event.
+	LambdaEventProxy = "proxy"
+	//LambdaEventHTTP is the HTTP API (payload format 2.0) event.
+	LambdaEventHTTP = "http"
+)
+
+// Supported storage backends.
+const (
+	//StorageDynamoDB stores users and requests on Amazon DynamoDB.
+	StorageDynamoDB = "dynamodb"
+	//StorageBolt stores users and requests in a local BoltDB file.
+	StorageBolt = "bolt"
+	//StorageMemory keeps users and requests in memory.
+	StorageMemory = "memory"
 )
 
 var (
@@ -28,18 +121,96 @@ var (
 	TelegramBotToken string
 	//BitlyAPIKey is the Bitly API key.
 	BitlyAPIKey string
-	//ReferralID is the Amazon referral ID.
-	ReferralID string
-	//AmazonDomain is the Amazon domain for which
-	//the ReferralID is valid.
-	AmazonDomain string
+	//BitlyDomain is the domain of the Bitly links.
+	//If empty, bit.ly is used.
+	BitlyDomain string
+	//BitlyGroupGUID is the Bitly group the links are created in.
+	//If empty, the default group of the user is used.
+	BitlyGroupGUID string
+	//Shortener is the service used to shorten the
+	//links. It defaults to ShortenerBitly.
+	Shortener string
+	//ShortenerURL is the base URL of the self-hosted
+	//shortener, used with ShortenerYOURLS and ShortenerKutt.
+	ShortenerURL string
+	//ShortenerKey is the signature or API key of the self-hosted shortener.
+	ShortenerKey string
+	//ShortenerRetries is the number of times a shortening is
+	//retried when the shortener is unavailable. It defaults to 2.
+	ShortenerRetries int
+	//ShortLinkHosts are the hosts of the link shorteners, besides
+	//the default ones, whose links the bot is allowed to resolve.
+	ShortLinkHosts []string
+	//InlineCacheTime is the time, in seconds, Telegram may cache
+	//the answers to the inline queries. It defaults to 300.
+	InlineCacheTime int
+	//GroupRepost tells whether the messages with Amazon links sent
+	//in groups and channels should be deleted and reposted with
+	//the referral links, when the bot is allowed to delete them.
+	GroupRepost bool
+	//ReplyMode is the way the referral links are sent
+	//to the users. It defaults to ReplyModeList.
+	ReplyMode string
+	//PAAPIAccessKey is the access key of the Product Advertising
+	//API. If it's empty, the details of the products are not shown.
+	PAAPIAccessKey string
+	//PAAPISecretKey is the secret key of the Product Advertising API.
+	PAAPISecretKey string
+	//LinkWorkers is the number of links of a message
+	//converted at the same time. It defaults to 4.
+	LinkWorkers int
+	//LinkTimeout is the time after which the conversion
+	//of a link fails. It defaults to 8 seconds.
+	LinkTimeout time.Duration
+	//LinkCacheTTL is the time the shortened referral links of the
+	//products are reused for. It defaults to 30 days and the links
+	//are not cached if it's zero.
+	LinkCacheTTL time.Duration
+	//LinkCacheSize is the number of shortened referral links
+	//kept in memory, besides the store. It defaults to 1000.
+	LinkCacheSize int
+	//ReferralIDs maps the Amazon marketplace domains,
+	//e.g. amazon.it, to their referral IDs.
+	ReferralIDs map[string]string
+	//StorageBackend is the backend used to store users and
+	//requests. It defaults to StorageDynamoDB.
+	StorageBackend string
+	//BoltDBPath is the path of the BoltDB file, used when
+	//StorageBackend is StorageBolt. It defaults to refbot.db.
+	BoltDBPath string
+	//AdminIDs are the Telegram IDs of the users
+	//marked as admins when the bot starts.
+	AdminIDs []int
+	//RunMode is the way the bot receives updates.
+	//It defaults to RunModeLambda.
+	RunMode string
+	//WebhookAddress is the address the webhook server listens
+	//on when RunMode is RunModeWebhook. It defaults to :8080.
+	WebhookAddress string
+	//WebhookPath is the path the webhook server receives
+	//the updates on. It defaults to /.
+	WebhookPath string
+	//WebhookReply tells whether the last text message should be
+	//sent in the body of the webhook response, saving an API call.
+	WebhookReply bool
+	//LambdaEvent is the event the Lambda function receives
+	//when RunMode is RunModeLambda. It defaults to LambdaEventUpdate.
+	LambdaEvent string
+	//WebhookSecretToken is the secret token set with setWebhook.
+	//If it's not empty, webhook requests without it are rejected.
+	WebhookSecretToken string
+	//WebhookCheckSourceIP tells whether webhook requests
+	//coming from outside Telegram's networks are rejected.
+	WebhookCheckSourceIP bool
+	//WebhookTrustedProxies are the networks of the reverse proxies
+	//in front of the webhook server. The source IP of the requests
+	//they forward is read from the X-Forwarded-For and X-Real-IP headers.
+	WebhookTrustedProxies []*net.IPNet
 
 	//AWS-related variables
 
 	//AWSSession is the current AWS session.
 	AWSSession *session.Session
-	//DynamoDBClient is the client to access DynamoDB.
-	DynamoDBClient *dynamodb.DynamoDB
 )
 
 // LoadEnvVariables loads the environment variables and checks their values.
@@ -51,19 +222,238 @@ func LoadEnvVariables() {
 		log.Fatalf("Missing Telegram bot token. Make sure you have it in your environment variables with the key %s", tgBotTokenName)
 	}
 
-	BitlyAPIKey = os.Getenv(bAPIKeyName)
-	if BitlyAPIKey == "" {
-		log.Fatalf("Missing Bitly API Key. Make sure you have it in your environment variables with the key %s", bAPIKeyName)
+	loadShortenerVariables()
+
+	ReferralIDs = loadReferralIDs()
+	if len(ReferralIDs) == 0 {
+		log.Fatalf("Missing referral IDs. Make sure you have them in your environment variables with the key %s (e.g. amazon.it=tag-21,amazon.de=tag-21) or with the keys %s and %s", refIDsKeyName, amazonDomain, refIDKeyName)
+	}
+
+	LoadStorageVariables()
+	AdminIDs = loadAdminIDs()
+
+	RunMode = os.Getenv(runModeKeyName)
+	switch RunMode {
+	case "":
+		RunMode = RunModeLambda
+	case RunModeLambda, RunModePolling, RunModeWebhook:
+	default:
+		log.Fatalf("Unknown run mode %s. The %s environment variable must be one of %s, %s or %s", RunMode, runModeKeyName, RunModeLambda, RunModePolling, RunModeWebhook)
+	}
+
+	WebhookAddress = os.Getenv(webhookAddressKeyName)
+	if WebhookAddress == "" {
+		WebhookAddress = ":8080"
+	}
+
+	WebhookPath = os.Getenv(webhookPathKeyName)
+	if WebhookPath == "" {
+		WebhookPath = "/"
+	}
+
+	WebhookReply = parseBoolVariable(webhookReplyKeyName)
+
+	LambdaEvent = os.Getenv(lambdaEventKeyName)
+	switch LambdaEvent {
+	case "":
+		LambdaEvent = LambdaEventUpdate
+	case LambdaEventUpdate, LambdaEventProxy, LambdaEventHTTP:
+	default:
+		log.Fatalf("Unknown Lambda event %s. The %s environment variable must be one of %s, %s or %s", LambdaEvent, lambdaEventKeyName, LambdaEventUpdate, LambdaEventProxy, LambdaEventHTTP)
+	}
+
+	ShortLinkHosts = nil
+	for _, host := range strings.Split(os.Getenv(shortLinkHostsKeyName), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			ShortLinkHosts = append(ShortLinkHosts, strings.ToLower(host))
+		}
+	}
+
+	ShortenerRetries = parseIntVariable(shortenerRetriesKeyName, 2, 0)
+	InlineCacheTime = parseIntVariable(inlineCacheTimeKeyName, 300, 0)
+	LinkWorkers = parseIntVariable(linkWorkersKeyName, 4, 1)
+	LinkTimeout = time.Duration(parseIntVariable(linkTimeoutKeyName, 8, 1)) * time.Second
+	LinkCacheTTL = time.Duration(parseIntVariable(linkCacheTTLKeyName, 720, 0)) * time.Hour
+	LinkCacheSize = parseIntVariable(linkCacheSizeKeyName, 1000, 1)
+
+	GroupRepost = parseBoolVariable(groupRepostKeyName)
+
+	ReplyMode = os.Getenv(replyModeKeyName)
+	switch ReplyMode {
+	case "":
+		ReplyMode = ReplyModeList
+	case ReplyModeList, ReplyModeRewrite:
+	default:
+		log.Fatalf("Unknown reply mode %s. The %s environment variable must be one of %s or %s", ReplyMode, replyModeKeyName, ReplyModeList, ReplyModeRewrite)
+	}
+
+	PAAPIAccessKey = os.Getenv(paapiAccessKeyKeyName)
+	PAAPISecretKey = os.Getenv(paapiSecretKeyKeyName)
+	if (PAAPIAccessKey == "") != (PAAPISecretKey == "") {
+		log.Fatalf("Incomplete Product Advertising API configuration. Make sure you have both the %s and %s environment variables", paapiAccessKeyKeyName, paapiSecretKeyKeyName)
+	}
+
+	WebhookSecretToken = os.Getenv(webhookSecretTokenKeyName)
+	WebhookCheckSourceIP = parseBoolVariable(webhookCheckSourceIPKeyName)
+	WebhookTrustedProxies = loadTrustedProxies()
+
+	// Without the proxy integration, the handler doesn't receive
+	// the headers and the source IP, so it can't check them.
+	if RunMode == RunModeLambda && LambdaEvent == LambdaEventUpdate && (WebhookSecretToken != "" || WebhookCheckSourceIP) {
+		log.Fatalf("Webhook verification requires the %s environment variable to be %s or %s", lambdaEventKeyName, LambdaEventProxy, LambdaEventHTTP)
+	}
+
+}
+
+// loadTrustedProxies reads the networks of the reverse proxies from the
+// WEBHOOK_TRUSTED_PROXIES environment variable, a comma-separated list of
+// IP addresses and CIDR networks. The application will quit if it's malformed.
+func loadTrustedProxies() []*net.IPNet {
+
+	var cidrs []string
+	for _, cidr := range strings.Split(os.Getenv(webhookTrustedProxiesKeyName), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+
+	proxies, err := networks.Parse(cidrs...)
+	if err != nil {
+		log.Fatalf("Invalid value for the %s environment variable: %s", webhookTrustedProxiesKeyName, err)
+	}
+
+	return proxies
+
+}
+
+// loadShortenerVariables loads the configuration of the URL shortener.
+// The application will quit if the chosen shortener is not configured.
+func loadShortenerVariables() {
+
+	Shortener = os.Getenv(shortenerKeyName)
+	if Shortener == "" {
+		Shortener = ShortenerBitly
+	}
+
+	switch Shortener {
+	case ShortenerBitly:
+		BitlyAPIKey = os.Getenv(bAPIKeyName)
+		if BitlyAPIKey == "" {
+			log.Fatalf("Missing Bitly API Key. Make sure you have it in your environment variables with the key %s", bAPIKeyName)
+		}
+		BitlyDomain = os.Getenv(bitlyDomainKeyName)
+		BitlyGroupGUID = os.Getenv(bitlyGroupGUIDKeyName)
+	case ShortenerYOURLS, ShortenerKutt:
+		ShortenerURL = os.Getenv(shortenerURLKeyName)
+		ShortenerKey = os.Getenv(shortenerKeyKeyName)
+		if ShortenerURL == "" || ShortenerKey == "" {
+			log.Fatalf("Missing shortener configuration. Make sure you have it in your environment variables with the keys %s and %s", shortenerURLKeyName, shortenerKeyKeyName)
+		}
+	case ShortenerNone:
+	default:
+		log.Fatalf("Unknown shortener %s. The %s environment variable must be one of %s, %s, %s or %s", Shortener, shortenerKeyName, ShortenerBitly, ShortenerYOURLS, ShortenerKutt, ShortenerNone)
+	}
+
+}
+
+// loadReferralIDs reads the referral IDs from the REF_IDS environment
+// variable, a comma-separated list of domain=referralID pairs, and
+// from the single marketplace REF_ID and AMAZON_DOMAIN variables.
+// The application will quit if REF_IDS is malformed.
+func loadReferralIDs() map[string]string {
+
+	referralIDs := make(map[string]string)
+
+	domain, referralID := os.Getenv(amazonDomain), os.Getenv(refIDKeyName)
+	if domain != "" && referralID != "" {
+		referralIDs[normalizeDomain(domain)] = referralID
+	}
+
+	value := os.Getenv(refIDsKeyName)
+	if value == "" {
+		return referralIDs
+	}
+
+	for _, pair := range strings.Split(value, ",") {
+
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+			log.Fatalf("Invalid referral ID %q in the %s environment variable. It must be in the domain=referralID format", pair, refIDsKeyName)
+		}
+
+		referralIDs[normalizeDomain(parts[0])] = strings.TrimSpace(parts[1])
+
+	}
+
+	return referralIDs
+
+}
+
+// loadAdminIDs reads the Telegram IDs of the admins from the
+// ADMIN_IDS environment variable, a comma-separated list.
+// The application will quit if it's malformed.
+func loadAdminIDs() []int {
+
+	var adminIDs []int
+	for _, value := range strings.Split(os.Getenv(adminIDsKeyName), ",") {
+
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		adminID, err := strconv.Atoi(value)
+		if err != nil || adminID <= 0 {
+			log.Fatalf("Invalid admin ID %q in the %s environment variable. It must be a comma-separated list of Telegram user IDs", value, adminIDsKeyName)
+		}
+
+		adminIDs = append(adminIDs, adminID)
+
+	}
+
+	return adminIDs
+
+}
+
+// normalizeDomain returns the lowercase domain without the www. prefix.
+func normalizeDomain(domain string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "www.")
+}
+
+// parseBoolVariable returns the value of a boolean environment variable.
+// It defaults to false and the application will quit if it's not valid.
+func parseBoolVariable(key string) bool {
+
+	value := os.Getenv(key)
+	if value == "" {
+		return false
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid value %s for the %s environment variable: %s", value, key, err)
 	}
 
-	ReferralID = os.Getenv(refIDKeyName)
-	if ReferralID == "" {
-		log.Fatalf("Missing referral ID. Make sure you have it in your environment variables with the key %s", refIDKeyName)
+	return parsed
+
+}
+
+// LoadStorageVariables loads the configuration of the storage
+// backend. It's also used on its own by the import command,
+// which doesn't need the rest of the configuration.
+func LoadStorageVariables() {
+
+	StorageBackend = os.Getenv(storageBackendKeyName)
+	switch StorageBackend {
+	case "":
+		StorageBackend = StorageDynamoDB
+	case StorageDynamoDB, StorageBolt, StorageMemory:
+	default:
+		log.Fatalf("Unknown storage backend %s. The %s environment variable must be one of %s, %s or %s", StorageBackend, storageBackendKeyName, StorageDynamoDB, StorageBolt, StorageMemory)
 	}
 
-	AmazonDomain = os.Getenv(amazonDomain)
-	if ReferralID == "" {
-		log.Fatalf("Missing Amazon domain. Make sure you have it in your environment variables with the key %s", amazonDomain)
+	BoltDBPath = os.Getenv(boltDBPathKeyName)
+	if BoltDBPath == "" {
+		BoltDBPath = "refbot.db"
 	}
 
 }
@@ -74,7 +464,21 @@ func CreateAWSSession() (err error) {
 	return
 }
 
-// StartDynamoDBClient starts the DynamoDB client.
-func StartDynamoDBClient() {
-	DynamoDBClient = dynamodb.New(AWSSession)
+// parseIntVariable returns the value of an integer environment variable.
+// It defaults to defaultValue and the application will quit if it's
+// not valid or lower than minimum.
+func parseIntVariable(key string, defaultValue int, minimum int) int {
+
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < minimum {
+		log.Fatalf("Invalid value %s for the %s environment variable: it must be a number not lower than %d", value, key, minimum)
+	}
+
+	return number
+
 }
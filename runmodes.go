@@ -0,0 +1,151 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/services"
+)
+
+const (
+	// pollingTimeout is the long polling timeout, in seconds.
+	pollingTimeout = 60
+	// shutdownTimeout is the time the webhook server has
+	// to complete the pending requests when shutting down.
+	shutdownTimeout = 10 * time.Second
+	// maxWebhookBodySize is the maximum size of an update, in bytes.
+	maxWebhookBodySize = 1 << 20
+)
+
+// runPolling receives the updates with getUpdates long polling
+// and handles them until the process is interrupted.
+func runPolling(bot *tgbotapi.BotAPI, deps services.Dependencies) {
+
+	// Telegram refuses getUpdates calls while a webhook is set.
+	// We won't remove it ourselves, as it may belong to a deployed bot.
+	info, err := bot.GetWebhookInfo()
+	if err != nil {
+		log.Fatalf("runPolling: unable to get webhook info: %s", err)
+	}
+
+	if info.IsSet() {
+		log.Fatalf("runPolling: a webhook is set to %s, delete it before using the %s run mode", info.URL, repository.RunModePolling)
+	}
+
+	config := tgbotapi.NewUpdate(0)
+	config.Timeout = pollingTimeout
+	updates := bot.GetUpdatesChan(config)
+	stop := notifyShutdown()
+
+	log.Println("runPolling: waiting for updates")
+	for {
+		select {
+		case update := <-updates:
+			HandleUpdate(update, bot, deps)
+		case <-stop:
+			log.Println("runPolling: shutting down")
+			bot.StopReceivingUpdates()
+			return
+		}
+	}
+
+}
+
+// runWebhook starts an HTTP server that receives the updates
+// Telegram sends to the webhook. When the process is interrupted,
+// the server stops accepting connections and waits for the
+// pending updates to be handled.
+func runWebhook(bot *tgbotapi.BotAPI, deps services.Dependencies) {
+
+	mux := http.NewServeMux()
+	mux.Handle(repository.WebhookPath, webhookHandler(bot, deps))
+	server := &http.Server{Addr: repository.WebhookAddress, Handler: mux}
+
+	done := make(chan struct{})
+	go func() {
+
+		<-notifyShutdown()
+		log.Println("runWebhook: shutting down")
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println("runWebhook: error while shutting down:", err)
+		}
+
+		close(done)
+
+	}()
+
+	log.Println("runWebhook: listening on", repository.WebhookAddress+repository.WebhookPath)
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatalf("runWebhook: unable to serve: %s", err)
+	}
+
+	<-done
+
+}
+
+// webhookHandler returns the HTTP handler that decodes
+// the updates sent by Telegram and handles them.
+func webhookHandler(bot sender.Sender, deps services.Dependencies) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+		if err != nil {
+			log.Println("webhookHandler: unable to read body:", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+
+		status, reply := handleWebhook(webhookRequest{
+			method:      r.Method,
+			secretToken: r.Header.Get(secretTokenHeader),
+			sourceIP:    clientIP(r),
+			body:        body,
+		}, bot, deps)
+		for key, value := range webhookHeaders(reply) {
+			w.Header().Set(key, value)
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write(reply)
+
+	})
+
+}
+
+// notifyShutdown returns a channel that is closed
+// when the process receives SIGINT or SIGTERM.
+func notifyShutdown() <-chan struct{} {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	stop := make(chan struct{})
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		close(stop)
+	}()
+
+	return stop
+
+}
@@ -0,0 +1,50 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_webhookHandler(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "GET request",
+			method:     http.MethodGet,
+			body:       "",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "Malformed update",
+			method:     http.MethodPost,
+			body:       `{"update_id":`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	handler := webhookHandler(nil, newTestDependencies())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body)))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("webhookHandler() status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+
+		})
+	}
+
+}
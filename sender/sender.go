@@ -0,0 +1,136 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sender contains the abstraction used to
+// send requests to Telegram.
+package sender
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Sender sends requests to Telegram.
+// It is implemented by *tgbotapi.BotAPI.
+type Sender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (tgbotapi.APIResponse, error)
+}
+
+// WebhookReply is a Sender that holds back the last text message,
+// so that it can be returned in the body of the webhook response
+// instead of being sent with another API call.
+// Every other request is sent right away, after the message held
+// back, if any, in order to preserve the order of the replies.
+type WebhookReply struct {
+	sender  Sender
+	mutex   sync.Mutex
+	pending *tgbotapi.MessageConfig
+}
+
+// NewWebhookReply returns a WebhookReply that uses the
+// provided Sender for the requests it doesn't hold back.
+func NewWebhookReply(s Sender) *WebhookReply {
+	return &WebhookReply{sender: s}
+}
+
+// Send holds back text messages and sends any other request.
+// The Message returned for the text messages held back is empty,
+// as Telegram does not report the result of webhook replies.
+func (w *WebhookReply) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.flush()
+	if err != nil {
+		return tgbotapi.Message{}, err
+	}
+
+	if message, ok := c.(tgbotapi.MessageConfig); ok {
+		w.pending = &message
+		return tgbotapi.Message{}, nil
+	}
+
+	return w.sender.Send(c)
+
+}
+
+// Request sends the request right away.
+func (w *WebhookReply) Request(c tgbotapi.Chattable) (tgbotapi.APIResponse, error) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	err := w.flush()
+	if err != nil {
+		return tgbotapi.APIResponse{}, err
+	}
+
+	return w.sender.Request(c)
+
+}
+
+// Body returns the JSON representation of the sendMessage call
+// to use as the webhook response. It returns nil if no message
+// was held back.
+func (w *WebhookReply) Body() ([]byte, error) {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.pending == nil {
+		return nil, nil
+	}
+
+	body := map[string]interface{}{
+		"method": "sendMessage",
+		"text":   w.pending.Text,
+	}
+
+	if w.pending.ChannelUsername != "" {
+		body["chat_id"] = w.pending.ChannelUsername
+	} else {
+		body["chat_id"] = w.pending.ChatID
+	}
+
+	if w.pending.ParseMode != "" {
+		body["parse_mode"] = w.pending.ParseMode
+	}
+
+	if w.pending.DisableWebPagePreview {
+		body["disable_web_page_preview"] = true
+	}
+
+	if w.pending.DisableNotification {
+		body["disable_notification"] = true
+	}
+
+	if w.pending.ReplyToMessageID != 0 {
+		body["reply_to_message_id"] = w.pending.ReplyToMessageID
+	}
+
+	if w.pending.ReplyMarkup != nil {
+		body["reply_markup"] = w.pending.ReplyMarkup
+	}
+
+	return json.Marshal(body)
+
+}
+
+// flush sends the message held back, if any.
+// The caller must hold the mutex.
+func (w *WebhookReply) flush() error {
+
+	if w.pending == nil {
+		return nil
+	}
+
+	_, err := w.sender.Send(*w.pending)
+	w.pending = nil
+	return err
+
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sender
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/sender/sendertest"
+)
+
+func TestWebhookReply(t *testing.T) {
+
+	first := tgbotapi.NewMessage(1, "first")
+	second := tgbotapi.NewMessage(1, "second")
+	second.ParseMode = "HTML"
+	second.ReplyToMessageID = 42
+	action := tgbotapi.NewChatAction(1, "typing")
+
+	tests := []struct {
+		name     string
+		requests []tgbotapi.Chattable
+		wantSent []tgbotapi.Chattable
+		wantBody map[string]interface{}
+	}{
+		{
+			name:     "No messages",
+			requests: []tgbotapi.Chattable{action},
+			wantSent: []tgbotapi.Chattable{action},
+			wantBody: nil,
+		},
+		{
+			name:     "Last message is held back",
+			requests: []tgbotapi.Chattable{action, first, second},
+			wantSent: []tgbotapi.Chattable{action, first},
+			wantBody: map[string]interface{}{
+				"method":              "sendMessage",
+				"chat_id":             float64(1),
+				"text":                "second",
+				"parse_mode":          "HTML",
+				"reply_to_message_id": float64(42),
+			},
+		},
+		{
+			name:     "Other requests flush the message",
+			requests: []tgbotapi.Chattable{first, action},
+			wantSent: []tgbotapi.Chattable{first, action},
+			wantBody: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			recorder := &sendertest.Recorder{}
+			reply := NewWebhookReply(recorder)
+			for _, request := range tt.requests {
+				_, _ = reply.Send(request)
+			}
+
+			if !reflect.DeepEqual(recorder.Sent, tt.wantSent) {
+				t.Errorf("WebhookReply sent %v, want %v", recorder.Sent, tt.wantSent)
+			}
+
+			body, err := reply.Body()
+			if err != nil {
+				t.Errorf("Body() error = %v", err)
+				return
+			}
+
+			var gotBody map[string]interface{}
+			if body != nil {
+				if err := json.Unmarshal(body, &gotBody); err != nil {
+					t.Errorf("Body() returned invalid JSON %s: %v", body, err)
+				}
+			}
+
+			if !reflect.DeepEqual(gotBody, tt.wantBody) {
+				t.Errorf("Body() = %v, want %v", gotBody, tt.wantBody)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,60 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sendertest provides a sender.Sender that
+// records the requests instead of sending them to Telegram.
+package sendertest
+
+import (
+	"sync"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// firstMessageID is the ID of the first message sent by a Recorder.
+const firstMessageID = 101
+
+// Recorder is a sender.Sender that records the requests it receives.
+// The sent messages get increasing IDs, starting from 101.
+// The requests fail with RequestErr, if set, and the sent
+// messages fail with the error returned by SendErr, if any.
+type Recorder struct {
+	Sent       []tgbotapi.Chattable
+	RequestErr error
+	SendErr    func(c tgbotapi.Chattable) error
+
+	mutex sync.Mutex
+}
+
+// Send records the message.
+func (r *Recorder) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Sent = append(r.Sent, c)
+	if r.SendErr != nil {
+		if err := r.SendErr(c); err != nil {
+			return tgbotapi.Message{}, err
+		}
+	}
+
+	return tgbotapi.Message{MessageID: firstMessageID - 1 + len(r.Sent)}, nil
+
+}
+
+// Request records the request.
+func (r *Recorder) Request(c tgbotapi.Chattable) (tgbotapi.APIResponse, error) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Sent = append(r.Sent, c)
+	if r.RequestErr != nil {
+		return tgbotapi.APIResponse{}, r.RequestErr
+	}
+
+	return tgbotapi.APIResponse{Ok: true}, nil
+
+}
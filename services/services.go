@@ -0,0 +1,29 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package services contains the services
+// the handlers of the updates depend on.
+package services
+
+import (
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/metadata"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/persistence"
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork"
+)
+
+// Dependencies are the services used to handle the updates.
+type Dependencies struct {
+	// Store persists users and requests.
+	Store persistence.Store
+	// Resolver resolves the short links users send.
+	Resolver urlwork.Resolver
+	// Shortener shortens the referral links.
+	Shortener urlwork.Shortener
+	// Links caches the shortened referral links.
+	// It's nil if the caching is disabled.
+	Links urlwork.LinkCache
+	// Products looks up the details of the products.
+	// It's nil if the lookups are disabled.
+	Products metadata.Provider
+}
@@ -0,0 +1,49 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package structs
+
+import (
+	"os"
+)
+
+const (
+	linkTableKey = "LINK_TABLE_NAME"
+)
+
+// Link represents the shortened referral link of a product.
+// It contains an unique key for the product, built from its
+// marketplace, ASIN, the referral ID used and the options of
+// the product, i.e. the encoded query parameters that select
+// its variant or seller, the shortened URL, an Unix
+// representation of the time it was created and the one
+// it expires at, to be used for the table TTL.
+type Link struct {
+	LinkKey     string
+	Marketplace string
+	ASIN        string
+	Tag         string
+	Options     string
+	URL         string
+	UnixTime    int64
+	ExpiresAt   int64
+}
+
+// Table returns the name of the Link table
+// reading it from the environment variables.
+func (Link) Table() string {
+	return os.Getenv(linkTableKey)
+}
+
+// LinkKey returns the key of the referral link to a product.
+func LinkKey(marketplace string, asin string, tag string, options string) string {
+
+	key := marketplace + ":" + asin + ":" + tag
+	if options != "" {
+		key += ":" + options
+	}
+
+	return key
+
+}
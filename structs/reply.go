@@ -0,0 +1,41 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package structs
+
+import (
+	"os"
+	"strconv"
+)
+
+const (
+	replyTableKey = "REPLY_TABLE_NAME"
+)
+
+// Reply represents the reply the bot sent to a message.
+// It contains an unique key for the message, built from
+// its chat and message IDs, the ID of the reply, the
+// referral URLs it contains, whether it's a photo of
+// the product and an Unix representation of the time
+// to be used for the table TTL, if needed.
+type Reply struct {
+	MessageKey string
+	ChatID     int64
+	MessageID  int
+	ReplyID    int
+	URLs       []string
+	IsPhoto    bool
+	UnixTime   int64
+}
+
+// Table returns the name of the Reply table
+// reading it from the environment variables.
+func (Reply) Table() string {
+	return os.Getenv(replyTableKey)
+}
+
+// ReplyKey returns the key of the reply to a message.
+func ReplyKey(chatID int64, messageID int) string {
+	return strconv.FormatInt(chatID, 10) + ":" + strconv.Itoa(messageID)
+}
@@ -18,15 +18,18 @@ const (
 // Request represents a request.
 // It contains an unique identifier, the Telegram
 // ID of the user, the URL that was returned to
-// the user, the timestamp of the request and an
+// the user, the marketplace and the ASIN of the
+// product, the timestamp of the request and an
 // Unix representation of the time to be used for
 // the table TTL, if needed.
 type Request struct {
-	XID        string
-	TelegramID int
-	URL        string
-	Time       time.Time
-	UnixTime   int64
+	XID         string
+	TelegramID  int
+	URL         string
+	Marketplace string
+	ASIN        string
+	Time        time.Time
+	UnixTime    int64
 }
 
 // Table returns the name of the Request table
@@ -0,0 +1,31 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package structs
+
+import (
+	"time"
+)
+
+// RequestStats contains the metrics of the requests made
+// in a period: the number of requests, of the users that
+// made them and of the ones that made their first request,
+// the most requested products and marketplaces and the
+// number of requests of each day, oldest first.
+type RequestStats struct {
+	Since           time.Time
+	Requests        int
+	Users           int
+	NewUsers        int
+	TopASINs        []Count
+	TopMarketplaces []Count
+	Days            []Count
+}
+
+// Count is the number of requests for a key,
+// e.g. an ASIN, a marketplace or a day.
+type Count struct {
+	Key      string
+	Requests int
+}
@@ -15,12 +15,16 @@ const (
 // User represents a telegram user.
 // It contains the Telegram ID of the user,
 // a boolean field indicating whether it's
-// an admin and a boolean flag that tells
-// if the user blocked the bot.
+// an admin, a boolean flag that tells
+// if the user blocked the bot and the
+// Unix time the user was first saved at,
+// zero for the users saved before it
+// was recorded.
 type User struct {
 	TelegramID    int
 	IsAdmin       bool
 	HasBlockedBot bool
+	FirstSeen     int64
 }
 
 // Table returns the name of the User table
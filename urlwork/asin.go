@@ -0,0 +1,113 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// asinPattern matches an ASIN: either a 10 characters code starting
+// with B or, for books, their ISBN-10.
+var asinPattern = regexp.MustCompile(`^(B[0-9A-Z]{9}|[0-9]{9}[0-9X])$`)
+
+// asinPrefixes are the path segments that precede the ASIN
+// in the known Amazon product URL shapes:
+//
+//	/dp/ASIN, /slug/dp/ASIN, /dp/product/ASIN
+//	/gp/product/ASIN, /gp/aw/d/ASIN, /gp/offer-listing/ASIN
+//	/exec/obidos/ASIN/ASIN, /exec/obidos/tg/detail/-/ASIN
+//	/o/ASIN/ASIN
+var asinPrefixes = [][]string{
+	{"dp", "product"},
+	{"dp"},
+	{"gp", "product"},
+	{"gp", "aw", "d"},
+	{"gp", "offer-listing"},
+	{"exec", "obidos", "asin"},
+	{"exec", "obidos", "tg", "detail", "-"},
+	{"o", "asin"},
+}
+
+// IsASIN returns true if the code is a valid ASIN.
+func IsASIN(code string) bool {
+	return asinPattern.MatchString(code)
+}
+
+// ExtractASIN returns the ASIN of the product an Amazon URL points to.
+// It looks for the ASIN in the path first and then in the asin
+// query parameter. It returns an error if the URL is not a product page.
+func ExtractASIN(u *url.URL) (string, error) {
+
+	var segments []string
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	for index := range segments {
+		for _, prefix := range asinPrefixes {
+
+			next := index + len(prefix)
+			if next >= len(segments) || !hasPrefixAt(segments, index, prefix) {
+				continue
+			}
+
+			if candidate := strings.ToUpper(segments[next]); IsASIN(candidate) {
+				return candidate, nil
+			}
+
+		}
+	}
+
+	for key, values := range u.Query() {
+		if strings.EqualFold(key, "asin") && len(values) > 0 {
+			if candidate := strings.ToUpper(values[0]); IsASIN(candidate) {
+				return candidate, nil
+			}
+		}
+	}
+
+	return "", errors.Errorf("%s is not an Amazon product URL", u.String())
+
+}
+
+// CanonicalURL returns the canonical referral URL of a product:
+// https://www.<marketplace>/dp/<ASIN>?tag=<referralID>
+// The options, e.g. the variant or the seller, are added to the
+// query only if allowed by FilterQuery.
+func CanonicalURL(marketplace string, asin string, referralID string, options url.Values) string {
+
+	query := FilterQuery(options)
+	query.Set("tag", referralID)
+
+	canonical := url.URL{
+		Scheme:   "https",
+		Host:     "www." + marketplace,
+		Path:     "/dp/" + asin,
+		RawQuery: query.Encode(),
+	}
+
+	return canonical.String()
+
+}
+
+// hasPrefixAt returns true if the segments, starting from
+// the index, match the prefix, ignoring the case.
+func hasPrefixAt(segments []string, index int, prefix []string) bool {
+
+	for offset, part := range prefix {
+		if !strings.EqualFold(segments[index+offset], part) {
+			return false
+		}
+	}
+
+	return true
+
+}
@@ -0,0 +1,102 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWorkers is the default number of
+	// links converted at the same time.
+	DefaultWorkers = 4
+	// DefaultLinkTimeout is the default time
+	// after which the conversion of a link fails.
+	DefaultLinkTimeout = 8 * time.Second
+)
+
+// ReferralResult is the result of the conversion of a link:
+// either its Referral or the error that prevented it.
+type ReferralResult struct {
+	Link     string
+	Referral Referral
+	Err      error
+}
+
+// GetReferrals converts the links like GetReferral, using at most
+// the given number of workers at the same time. The conversion of a
+// link fails if it takes longer than the timeout: its requests are
+// cancelled, so that no work goes on after the results are returned.
+// Non-positive values of workers and timeout are replaced by
+// DefaultWorkers and DefaultLinkTimeout.
+// The results are in the same order as the links.
+func GetReferrals(links []string, referralIDs map[string]string, resolver Resolver, shortener Shortener, cache LinkCache, workers int, timeout time.Duration) []ReferralResult {
+
+	results := make([]ReferralResult, len(links))
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	if timeout <= 0 {
+		timeout = DefaultLinkTimeout
+	}
+	if workers > len(links) {
+		workers = len(links)
+	}
+
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = getReferralWithTimeout(links[i], referralIDs, resolver, shortener, cache, timeout)
+			}
+		}()
+	}
+
+	for i := range links {
+		jobs <- i
+	}
+	close(jobs)
+
+	wg.Wait()
+	return results
+
+}
+
+// getReferralWithTimeout converts a link, failing if it takes longer
+// than the timeout. The context passed to GetReferral is cancelled
+// on return, stopping the requests of the abandoned conversions.
+func getReferralWithTimeout(link string, referralIDs map[string]string, resolver Resolver, shortener Shortener, cache LinkCache, timeout time.Duration) ReferralResult {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// The channel is buffered so that the goroutine can always
+	// return, even after the timeout. Waiting for the context too
+	// keeps the timeout even if the cache doesn't support it.
+	done := make(chan ReferralResult, 1)
+	go func() {
+		referral, err := GetReferral(ctx, link, referralIDs, resolver, shortener, cache)
+		done <- ReferralResult{Link: link, Referral: referral, Err: err}
+	}()
+
+	select {
+	case result := <-done:
+		// Conversions failed because of the cancelled
+		// requests are reported as timeouts too.
+		if result.Err == nil || ctx.Err() == nil {
+			return result
+		}
+	case <-ctx.Done():
+	}
+
+	return ReferralResult{Link: link, Err: newLinkError(KindTimeout, link, "Timeout while generating the referral link for URL %s", link)}
+
+}
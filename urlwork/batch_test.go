@@ -0,0 +1,128 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingResolver resolves amzn.to/<ASIN> links to their product
+// page once release is closed, tracking the concurrent calls.
+// Links in stuck never resolve: their calls return once the
+// context is done, signalling it on cancelled.
+type blockingResolver struct {
+	mutex      sync.Mutex
+	running    int
+	maxRunning int
+	started    chan struct{}
+	release    chan struct{}
+	stuck      map[string]bool
+	cancelled  chan struct{}
+}
+
+func (b *blockingResolver) Resolve(ctx context.Context, u *url.URL) (*url.URL, error) {
+
+	b.mutex.Lock()
+	b.running++
+	if b.running > b.maxRunning {
+		b.maxRunning = b.running
+	}
+	b.mutex.Unlock()
+
+	b.started <- struct{}{}
+	<-b.release
+
+	b.mutex.Lock()
+	b.running--
+	b.mutex.Unlock()
+
+	if b.stuck[u.String()] {
+		<-ctx.Done()
+		b.cancelled <- struct{}{}
+		return nil, ctx.Err()
+	}
+
+	return url.Parse("https://www.amazon.it/dp" + u.Path)
+
+}
+
+func TestGetReferrals(t *testing.T) {
+
+	referralIDs := map[string]string{"amazon.it": "italian-21"}
+
+	var links, want []string
+	for i := 0; i < 10; i++ {
+		asin := fmt.Sprintf("B00TEST%03d", i)
+		links = append(links, "https://amzn.to/"+asin)
+		want = append(want, "https://www.amazon.it/dp/"+asin+"?tag=italian-21")
+	}
+
+	// A link that doesn't need the resolver and an invalid one.
+	links = append(links, "https://www.amazon.it/dp/B00TEST999", "https://www.example.com")
+	want = append(want, "https://www.amazon.it/dp/B00TEST999?tag=italian-21", "")
+
+	resolver := &blockingResolver{started: make(chan struct{}, len(links)), release: make(chan struct{})}
+
+	// Release the resolver once the workers are all busy.
+	go func() {
+		for i := 0; i < 3; i++ {
+			<-resolver.started
+		}
+		close(resolver.release)
+	}()
+
+	results := GetReferrals(links, referralIDs, resolver, NoopShortener{}, nil, 3, time.Minute)
+	if len(results) != len(links) {
+		t.Fatalf("GetReferrals() returned %d results, want %d", len(results), len(links))
+	}
+
+	for i, result := range results {
+		if result.Link != links[i] || result.Referral.URL != want[i] || (result.Err != nil) != (want[i] == "") {
+			t.Errorf("GetReferrals()[%d] = %+v, want %s for %s", i, result, want[i], links[i])
+		}
+	}
+
+	if resolver.maxRunning != 3 {
+		t.Errorf("GetReferrals() resolved %d links at the same time, want 3", resolver.maxRunning)
+	}
+
+}
+
+func TestGetReferrals_timeout(t *testing.T) {
+
+	referralIDs := map[string]string{"amazon.it": "italian-21"}
+	links := []string{"https://amzn.to/B00TEST001", "https://amzn.to/B00TEST002"}
+
+	resolver := &blockingResolver{
+		started:   make(chan struct{}, len(links)),
+		release:   make(chan struct{}),
+		stuck:     map[string]bool{links[0]: true},
+		cancelled: make(chan struct{}, 1),
+	}
+	close(resolver.release)
+
+	results := GetReferrals(links, referralIDs, resolver, NoopShortener{}, nil, 1, 50*time.Millisecond)
+
+	if KindOf(results[0].Err) != KindTimeout {
+		t.Errorf("GetReferrals() of a stuck link = %+v, want a timeout", results[0])
+	}
+
+	if results[1].Err != nil || results[1].Referral.URL != "https://www.amazon.it/dp/B00TEST002?tag=italian-21" {
+		t.Errorf("GetReferrals() after a stuck link = %+v, want the referral URL", results[1])
+	}
+
+	// The abandoned resolution must have been cancelled.
+	select {
+	case <-resolver.cancelled:
+	case <-time.After(time.Second):
+		t.Error("GetReferrals() didn't cancel the resolution of the stuck link")
+	}
+
+}
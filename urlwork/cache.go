@@ -0,0 +1,79 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"time"
+)
+
+// shortenerDeadlineMargin is the time left between the end of
+// the shortening attempts and the deadline of the conversion,
+// so that the long referral link can still be returned.
+const shortenerDeadlineMargin = 500 * time.Millisecond
+
+// LinkCache caches the shortened referral links of the products,
+// so that the same product is not shortened every time it's shared.
+// The options are the encoded query parameters kept in the links.
+// Implementations must be safe for concurrent use.
+type LinkCache interface {
+	// GetLink returns the shortened referral link of a product, if any.
+	GetLink(marketplace string, asin string, tag string, options string) (shortURL string, found bool, err error)
+	// PutLink caches the shortened referral link of a product.
+	PutLink(marketplace string, asin string, tag string, options string, shortURL string) error
+}
+
+// shortenReferral returns the shortened referral link of a product,
+// reusing the cached one, if any. A nil cache disables the caching.
+// The cache is only an optimization, so its errors are just logged.
+// If the shortener is rate limited or unavailable, the long referral
+// link is returned, so that users still get a working link. It's
+// not cached, so that it's shortened the next time. The same happens
+// if the shortener is still working shortly before the deadline
+// of the context, if any.
+func shortenReferral(ctx context.Context, marketplace string, asin string, tag string, options url.Values, shortener Shortener, cache LinkCache) (string, error) {
+
+	if cache != nil {
+
+		shortURL, found, err := cache.GetLink(marketplace, asin, tag, options.Encode())
+		if err != nil {
+			log.Println("shortenReferral: unable to read the cached link:", err)
+		}
+
+		if found {
+			return shortURL, nil
+		}
+
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-shortenerDeadlineMargin))
+		defer cancel()
+	}
+
+	longURL := CanonicalURL(marketplace, asin, tag, options)
+	shortURL, err := shortenURL(ctx, longURL, shortener)
+	if isTemporary(err) || err != nil && ctx.Err() != nil {
+		log.Println("shortenReferral: using the long referral link:", err)
+		return longURL, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if cache != nil {
+		err = cache.PutLink(marketplace, asin, tag, options.Encode(), shortURL)
+		if err != nil {
+			log.Println("shortenReferral: unable to cache the link:", err)
+		}
+	}
+
+	return shortURL, nil
+
+}
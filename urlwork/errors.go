@@ -0,0 +1,77 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ErrorKind tells why a referral link couldn't be generated.
+type ErrorKind int
+
+// Kinds of LinkError.
+const (
+	// KindUnknown is the kind of the errors that are not LinkErrors.
+	KindUnknown ErrorKind = iota
+	// KindInvalidURL means that the link is not a valid URL.
+	KindInvalidURL
+	// KindNotAmazon means that the link doesn't point
+	// to Amazon, even after following its redirects.
+	KindNotAmazon
+	// KindUnsupportedMarketplace means that there's no
+	// referral ID for the marketplace of the link.
+	KindUnsupportedMarketplace
+	// KindNotAProduct means that the link doesn't
+	// point to the page of a product.
+	KindNotAProduct
+	// KindResolverFailure means that the short link couldn't be resolved.
+	KindResolverFailure
+	// KindShortenerFailure means that the referral link couldn't be shortened.
+	KindShortenerFailure
+	// KindTimeout means that the link took too long to be converted.
+	KindTimeout
+)
+
+// ErrHostNotAllowed is returned by HTTPResolver when a
+// link, or one of its redirects, points to a host that's
+// neither Amazon's nor an allowed link shortener.
+var ErrHostNotAllowed = errors.New("host not allowed")
+
+// LinkError is the error returned when a referral
+// link can't be generated for a link.
+type LinkError struct {
+	Kind ErrorKind
+	Link string
+	Err  error
+}
+
+// Error returns the message of the underlying error.
+func (e *LinkError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *LinkError) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of the LinkError in the chain
+// of err, or KindUnknown if there's none.
+func KindOf(err error) ErrorKind {
+
+	var linkErr *LinkError
+	if errors.As(err, &linkErr) {
+		return linkErr.Kind
+	}
+
+	return KindUnknown
+
+}
+
+// newLinkError returns a LinkError of the given kind
+// for the link, formatting the error message.
+func newLinkError(kind ErrorKind, link string, format string, args ...interface{}) *LinkError {
+	return &LinkError{Kind: kind, Link: link, Err: errors.Errorf(format, args...)}
+}
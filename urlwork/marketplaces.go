@@ -0,0 +1,93 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// knownMarketplaces are the domains of the Amazon marketplaces.
+// They're used to tell apart Amazon URLs for marketplaces
+// without a referral ID from URLs that aren't Amazon's at all.
+var knownMarketplaces = []string{
+	"amazon.ae",
+	"amazon.ca",
+	"amazon.cn",
+	"amazon.co.jp",
+	"amazon.co.uk",
+	"amazon.com",
+	"amazon.com.au",
+	"amazon.com.be",
+	"amazon.com.br",
+	"amazon.com.mx",
+	"amazon.com.tr",
+	"amazon.de",
+	"amazon.eg",
+	"amazon.es",
+	"amazon.fr",
+	"amazon.in",
+	"amazon.it",
+	"amazon.nl",
+	"amazon.pl",
+	"amazon.sa",
+	"amazon.se",
+	"amazon.sg",
+}
+
+// MarketplaceOf returns the Amazon marketplace domain the host,
+// without port, belongs to, e.g. amazon.co.uk for www.amazon.co.uk.
+// It returns an empty string if the host is not Amazon's.
+func MarketplaceOf(host string) string {
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, marketplace := range knownMarketplaces {
+		if host == marketplace || strings.HasSuffix(host, "."+marketplace) {
+			return marketplace
+		}
+	}
+
+	return ""
+
+}
+
+// ReferralIDFor returns the marketplace of the host and the
+// referral ID to use for it. It returns an error if the host
+// is not Amazon's or if there's no referral ID for its marketplace.
+func ReferralIDFor(host string, referralIDs map[string]string) (marketplace string, referralID string, err error) {
+
+	marketplace = MarketplaceOf(host)
+	if marketplace == "" {
+		err = errors.Errorf("%s is not an Amazon domain", host)
+		return
+	}
+
+	referralID, ok := referralIDs[marketplace]
+	if !ok || referralID == "" {
+		err = errors.Errorf("the %s marketplace is not supported, supported marketplaces are: %s", marketplace, strings.Join(SupportedMarketplaces(referralIDs), ", "))
+	}
+
+	return
+
+}
+
+// SupportedMarketplaces returns the sorted list of the
+// marketplaces for which there is a referral ID.
+func SupportedMarketplaces(referralIDs map[string]string) []string {
+
+	marketplaces := make([]string, 0, len(referralIDs))
+	for marketplace, referralID := range referralIDs {
+		if referralID != "" {
+			marketplaces = append(marketplaces, marketplace)
+		}
+	}
+
+	sort.Strings(marketplaces)
+	return marketplaces
+
+}
@@ -0,0 +1,99 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReferralIDFor(t *testing.T) {
+
+	referralIDs := map[string]string{
+		"amazon.it":    "italian-21",
+		"amazon.co.uk": "british-21",
+		"amazon.com":   "american-20",
+	}
+
+	tests := []struct {
+		name            string
+		host            string
+		wantMarketplace string
+		wantReferralID  string
+		wantErr         bool
+	}{
+		{
+			name:            "www.amazon.it",
+			host:            "www.amazon.it",
+			wantMarketplace: "amazon.it",
+			wantReferralID:  "italian-21",
+		},
+		{
+			name:            "Mobile subdomain",
+			host:            "m.amazon.co.uk",
+			wantMarketplace: "amazon.co.uk",
+			wantReferralID:  "british-21",
+		},
+		{
+			name:            "Uppercase host",
+			host:            "WWW.AMAZON.COM",
+			wantMarketplace: "amazon.com",
+			wantReferralID:  "american-20",
+		},
+		{
+			name:            "Marketplace sharing a suffix",
+			host:            "www.amazon.com.br",
+			wantMarketplace: "amazon.com.br",
+			wantErr:         true,
+		},
+		{
+			name:            "Unsupported marketplace",
+			host:            "www.amazon.de",
+			wantMarketplace: "amazon.de",
+			wantErr:         true,
+		},
+		{
+			name:    "Lookalike domain",
+			host:    "www.notamazon.it",
+			wantErr: true,
+		},
+		{
+			name:    "Not an Amazon domain",
+			host:    "bitly.com",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			marketplace, referralID, err := ReferralIDFor(tt.host, referralIDs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReferralIDFor() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if marketplace != tt.wantMarketplace {
+				t.Errorf("ReferralIDFor() marketplace = %v, want %v", marketplace, tt.wantMarketplace)
+			}
+
+			if referralID != tt.wantReferralID {
+				t.Errorf("ReferralIDFor() referralID = %v, want %v", referralID, tt.wantReferralID)
+			}
+
+		})
+	}
+
+}
+
+func TestSupportedMarketplaces(t *testing.T) {
+
+	got := SupportedMarketplaces(map[string]string{"amazon.it": "a", "amazon.de": "b", "amazon.fr": ""})
+	want := []string{"amazon.de", "amazon.it"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SupportedMarketplaces() = %v, want %v", got, want)
+	}
+
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"net/url"
+	"strings"
+)
+
+// keptParameters are the query parameters of the Amazon
+// links kept in the referral links: th and psc select
+// the variant of the product, smid the seller.
+var keptParameters = map[string]bool{
+	"th":   true,
+	"psc":  true,
+	"smid": true,
+}
+
+// trackingParameters are the query parameters that track where
+// the link comes from, or compete with our referral ID. They're
+// never kept, even if added to keptParameters by mistake.
+var trackingParameters = map[string]bool{
+	"ref":       true,
+	"ref_":      true,
+	"qid":       true,
+	"sr":        true,
+	"keywords":  true,
+	"tag":       true,
+	"linkcode":  true,
+	"linkid":    true,
+	"ascsubtag": true,
+	"creative":  true,
+	"camp":      true,
+}
+
+// trackingPrefixes are the prefixes of the tracking
+// parameters with many variants, e.g. pf_rd_p.
+var trackingPrefixes = []string{"pf_rd_", "pd_rd_"}
+
+// FilterQuery returns the query parameters kept in the referral
+// links: the ones that select the variant or the seller of the
+// product. Tracking parameters and any other one are removed.
+// Only the first value of each parameter is kept.
+func FilterQuery(query url.Values) url.Values {
+
+	filtered := url.Values{}
+	for key, values := range query {
+
+		name := strings.ToLower(key)
+		if len(values) == 0 || !keptParameters[name] || IsTrackingParameter(name) {
+			continue
+		}
+
+		filtered.Set(name, values[0])
+
+	}
+
+	return filtered
+
+}
+
+// IsTrackingParameter returns true if the query parameter
+// is used to track the links or to set a referral ID.
+func IsTrackingParameter(key string) bool {
+
+	key = strings.ToLower(key)
+	if trackingParameters[key] {
+		return true
+	}
+
+	for _, prefix := range trackingPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+
+}
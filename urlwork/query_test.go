@@ -0,0 +1,69 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFilterQuery(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		query string
+		want  url.Values
+	}{
+		{"empty", "", url.Values{}},
+		{"variant", "th=1&psc=1", url.Values{"th": {"1"}, "psc": {"1"}}},
+		{"seller", "smid=A11IL2PNWYJU7H&ie=UTF8", url.Values{"smid": {"A11IL2PNWYJU7H"}}},
+		{"case", "TH=1&Psc=1", url.Values{"th": {"1"}, "psc": {"1"}}},
+		{"first value", "th=1&th=2", url.Values{"th": {"1"}}},
+		{
+			"tracking",
+			"ref_=nav&pf_rd_p=a&pf_rd_r=b&pd_rd_w=c&pd_rd_wg=d&qid=1&sr=8-1&keywords=kindle&tag=x-21&linkCode=ll1&ascsubtag=y",
+			url.Values{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+
+			if got := FilterQuery(query); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FilterQuery() = %v, want %v", got, tt.want)
+			}
+
+		})
+	}
+
+}
+
+func TestIsTrackingParameter(t *testing.T) {
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"tag", true},
+		{"linkCode", true},
+		{"pf_rd_p", true},
+		{"pd_rd_i", true},
+		{"th", false},
+		{"smid", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsTrackingParameter(tt.key); got != tt.want {
+			t.Errorf("IsTrackingParameter(%s) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+
+}
@@ -0,0 +1,265 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/networks"
+)
+
+const (
+	// DefaultMaxRedirects is the default maximum number
+	// of redirects followed to resolve a link.
+	DefaultMaxRedirects = 5
+	// DefaultResolverTimeout is the default time
+	// allowed to resolve a link, redirects included.
+	DefaultResolverTimeout = 5 * time.Second
+)
+
+// DefaultShortLinkHosts are the hosts of the link shorteners
+// the resolver is allowed to contact by default.
+var DefaultShortLinkHosts = []string{
+	"amzn.to",
+	"amzn.eu",
+	"amzn.asia",
+	"a.co",
+	"bit.ly",
+	"j.mp",
+}
+
+// Resolver resolves short links to the URL they redirect to.
+// It gives up as soon as the context is done.
+type Resolver interface {
+	Resolve(ctx context.Context, u *url.URL) (*url.URL, error)
+}
+
+// ResolveOffline returns the Amazon product URL a link points
+// to, if it can be found without network requests: this is the
+// case of Amazon marketplace URLs and of the old amzn.com/<ASIN>
+// short links. It returns false if the link must be resolved online.
+func ResolveOffline(u *url.URL) (*url.URL, bool) {
+
+	if MarketplaceOf(u.Hostname()) != "" {
+		return u, true
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host != "amzn.com" && host != "www.amzn.com" {
+		return nil, false
+	}
+
+	asin := strings.ToUpper(strings.Trim(u.Path, "/"))
+	if !IsASIN(asin) {
+		return nil, false
+	}
+
+	return &url.URL{Scheme: "https", Host: "www.amazon.com", Path: "/dp/" + asin}, true
+
+}
+
+// HTTPResolver is a Resolver that follows the redirects of
+// short links. To avoid being used to reach internal services,
+// it only contacts the allowed hosts, refuses private and
+// loopback addresses and stops as soon as a redirect points
+// to an Amazon marketplace, without requesting the page.
+type HTTPResolver struct {
+	// AllowedHosts are the hosts the resolver may send requests to.
+	AllowedHosts []string
+	// MaxRedirects is the maximum number of redirects to follow.
+	MaxRedirects int
+	// Client is the HTTP client used for the requests.
+	// It must not follow redirects on its own.
+	Client *http.Client
+}
+
+// NewHTTPResolver returns an HTTPResolver allowed to contact the provided
+// hosts, with the default timeout and maximum number of redirects.
+func NewHTTPResolver(allowedHosts []string) *HTTPResolver {
+	return newHTTPResolver(allowedHosts, isPublicIP)
+}
+
+// newHTTPResolver returns an HTTPResolver whose connections
+// are only allowed to the IP addresses accepted by isAllowedIP.
+func newHTTPResolver(allowedHosts []string, isAllowedIP func(ip net.IP) bool) *HTTPResolver {
+
+	// The control function is called after the host has been resolved,
+	// so it can't be fooled by DNS records pointing to private addresses.
+	dialer := &net.Dialer{
+		Timeout: DefaultResolverTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || !isAllowedIP(ip) {
+				return errors.Errorf("connections to %s are not allowed", host)
+			}
+
+			return nil
+
+		},
+	}
+
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: DefaultResolverTimeout,
+	}
+
+	return &HTTPResolver{
+		AllowedHosts: allowedHosts,
+		MaxRedirects: DefaultMaxRedirects,
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   DefaultResolverTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+}
+
+// Resolve follows the redirects of the link until it points to
+// an Amazon marketplace or to a page that doesn't redirect.
+// Amazon links are returned as they are. The timeout of the
+// client applies to all the redirects, as well as the context.
+func (r *HTTPResolver) Resolve(ctx context.Context, u *url.URL) (*url.URL, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, r.Client.Timeout)
+	defer cancel()
+
+	current := u
+	for hop := 0; ; hop++ {
+
+		if MarketplaceOf(current.Hostname()) != "" {
+			return current, nil
+		}
+
+		if hop > r.MaxRedirects {
+			return nil, errors.Errorf("Resolve: too many redirects for %s", u)
+		}
+
+		if current.Scheme != "http" && current.Scheme != "https" {
+			return nil, errors.Errorf("Resolve: unsupported scheme in %s", current)
+		}
+
+		if !r.isAllowedHost(current.Hostname()) {
+			return nil, errors.Wrapf(ErrHostNotAllowed, "Resolve: %s is not an allowed short link host", current.Hostname())
+		}
+
+		next, err := r.next(ctx, current)
+		if err != nil {
+			return nil, errors.Errorf("Resolve: %s", err)
+		}
+
+		if next == nil {
+			return current, nil
+		}
+
+		current = next
+
+	}
+
+}
+
+// next returns the URL the link redirects to, or nil if it doesn't.
+// Some servers reject HEAD requests: in that case, it tries with GET.
+func (r *HTTPResolver) next(ctx context.Context, u *url.URL) (*url.URL, error) {
+
+	response, err := r.request(ctx, http.MethodHead, u)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented ||
+		response.StatusCode == http.StatusForbidden || response.StatusCode == http.StatusNotFound {
+		response, err = r.request(ctx, http.MethodGet, u)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if response.StatusCode/100 != 3 {
+		if response.StatusCode/100 != 2 {
+			return nil, errors.Errorf("%s replied with status %d", u, response.StatusCode)
+		}
+		return nil, nil
+	}
+
+	location := response.Header.Get("Location")
+	if location == "" {
+		return nil, errors.Errorf("%s replied with a redirect without location", u)
+	}
+
+	return u.Parse(location)
+
+}
+
+// request performs a request and closes the body of the response,
+// as only the status code and the headers are needed.
+func (r *HTTPResolver) request(ctx context.Context, method string, u *url.URL) (*http.Response, error) {
+
+	request, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := r.Client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	_ = response.Body.Close()
+	return response, nil
+
+}
+
+// isAllowedHost returns true if the host is among the allowed ones.
+func (r *HTTPResolver) isAllowedHost(host string) bool {
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range r.AllowedHosts {
+		if host == strings.ToLower(allowed) {
+			return true
+		}
+	}
+
+	return false
+
+}
+
+// isPublicIP returns false for loopback, private, link-local,
+// multicast and unspecified addresses.
+func isPublicIP(ip net.IP) bool {
+
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+
+	return !networks.Contain(privateNetworks, ip)
+
+}
+
+// privateNetworks are the networks reserved for private use,
+// including the shared address space and the IPv6 unique local addresses.
+var privateNetworks = networks.MustParse(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"100.64.0.0/10",
+	"fc00::/7",
+)
@@ -0,0 +1,68 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultShortenerRetries is the default number of times
+	// a shortening is retried when the service is unavailable.
+	DefaultShortenerRetries = 2
+	// DefaultShortenerBackoff is the default time waited
+	// before the first retry. It doubles after each one.
+	DefaultShortenerBackoff = 500 * time.Millisecond
+)
+
+// RetryShortener is a Shortener that retries the shortenings
+// failed because the service was unavailable, waiting longer
+// after each attempt. The ones refused because of a rate limit
+// are not retried, as the limits usually last much longer
+// than the users are willing to wait.
+type RetryShortener struct {
+	// Shortener is the Shortener whose failures are retried.
+	Shortener Shortener
+	// Retries is the maximum number of retries.
+	Retries int
+	// Backoff is the time waited before the first retry.
+	Backoff time.Duration
+}
+
+// NewRetryShortener returns a RetryShortener that retries the
+// shortenings at most the given number of times, waiting the
+// backoff before the first retry and doubling it after each one.
+func NewRetryShortener(shortener Shortener, retries int, backoff time.Duration) *RetryShortener {
+	return &RetryShortener{Shortener: shortener, Retries: retries, Backoff: backoff}
+}
+
+// Shorten shortens a URL, retrying if the service is unavailable.
+// It stops retrying when the context is done, returning the last error.
+func (r *RetryShortener) Shorten(ctx context.Context, longURL string) (string, error) {
+
+	backoff := r.Backoff
+	for attempt := 0; ; attempt++ {
+
+		shortURL, err := r.Shortener.Shorten(ctx, longURL)
+		if err == nil || attempt >= r.Retries || errors.Cause(err) != ErrShortenerUnavailable {
+			return shortURL, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return "", err
+		case <-timer.C:
+		}
+
+		backoff *= 2
+
+	}
+
+}
@@ -0,0 +1,337 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// BitlyBaseURL is the base URL of the Bitly v4 API.
+	BitlyBaseURL = "https://api-ssl.bitly.com/v4/"
+	// BitlyDefaultDomain is the domain of the Bitly links
+	// when no custom domain is configured.
+	BitlyDefaultDomain = "bit.ly"
+
+	// shortenerTimeout is the default timeout of the shortener requests.
+	// When converting links, they also end before the link timeout.
+	shortenerTimeout = 10 * time.Second
+	// maxShortenerResponseSize is the maximum size of
+	// a shortener response body, in bytes.
+	maxShortenerResponseSize = 1 << 20
+)
+
+var (
+	// ErrRateLimited is the cause of the errors of the shortenings
+	// refused because the rate limit or the quota were exceeded.
+	ErrRateLimited = errors.New("shortener rate limit exceeded")
+	// ErrShortenerUnavailable is the cause of the errors of the
+	// shortenings failed because the service couldn't be reached
+	// or had an internal error. They may succeed if retried.
+	ErrShortenerUnavailable = errors.New("shortener unavailable")
+)
+
+// Shortener shortens URLs.
+// It gives up as soon as the context is done.
+type Shortener interface {
+	Shorten(ctx context.Context, longURL string) (string, error)
+}
+
+// NoopShortener is a Shortener that returns the long URL as is.
+type NoopShortener struct{}
+
+// Shorten returns the long URL.
+func (NoopShortener) Shorten(_ context.Context, longURL string) (string, error) {
+	return longURL, nil
+}
+
+// BitlyShortener shortens URLs with the Bitly v4 API.
+type BitlyShortener struct {
+	// AccessToken is the Bitly API key.
+	AccessToken string
+	// Domain is the domain of the links, e.g. a custom
+	// branded domain. It defaults to BitlyDefaultDomain.
+	Domain string
+	// GroupGUID is the group the links are created in.
+	// If empty, Bitly uses the default group of the user.
+	GroupGUID string
+	// BaseURL is the base URL of the API. It defaults to BitlyBaseURL.
+	BaseURL string
+	// Client is the HTTP client used for the requests.
+	Client *http.Client
+}
+
+// NewBitlyShortener returns a BitlyShortener for the provided API key.
+// The domain and the group GUID are optional.
+func NewBitlyShortener(accessToken string, domain string, groupGUID string) *BitlyShortener {
+
+	if domain == "" {
+		domain = BitlyDefaultDomain
+	}
+
+	return &BitlyShortener{
+		AccessToken: accessToken,
+		Domain:      domain,
+		GroupGUID:   groupGUID,
+		BaseURL:     BitlyBaseURL,
+		Client:      &http.Client{Timeout: shortenerTimeout},
+	}
+
+}
+
+// bitlyShortenRequest is the body of a Bitly shorten request.
+type bitlyShortenRequest struct {
+	LongURL   string `json:"long_url"`
+	Domain    string `json:"domain,omitempty"`
+	GroupGUID string `json:"group_guid,omitempty"`
+}
+
+// bitlyShortenResponse contains the fields of a Bitly
+// shorten response or error we are interested in.
+type bitlyShortenResponse struct {
+	Link        string `json:"link"`
+	Message     string `json:"message"`
+	Description string `json:"description"`
+}
+
+// Shorten shortens a URL using Bitly.
+func (b *BitlyShortener) Shorten(ctx context.Context, longURL string) (string, error) {
+
+	payload, err := json.Marshal(bitlyShortenRequest{
+		LongURL:   longURL,
+		Domain:    b.Domain,
+		GroupGUID: b.GroupGUID,
+	})
+
+	if err != nil {
+		return "", errors.Errorf("BitlyShortener: unable to marshal request: %s", err)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(b.BaseURL, "/")+"/shorten", bytes.NewReader(payload))
+	if err != nil {
+		return "", errors.Errorf("BitlyShortener: unable to create request: %s", err)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+b.AccessToken)
+	request.Header.Set("Content-Type", "application/json")
+
+	var response bitlyShortenResponse
+	status, err := doJSON(b.Client, request.WithContext(ctx), &response)
+	if err != nil {
+		return "", errors.Wrapf(ErrShortenerUnavailable, "BitlyShortener: %s", err)
+	}
+
+	// Bitly also reports the exceeded monthly quota,
+	// MONTHLY_RATE_LIMIT_EXCEEDED, with other status codes.
+	if status == http.StatusTooManyRequests || strings.Contains(response.Message, "RATE_LIMIT_EXCEEDED") {
+		return "", errors.Wrapf(ErrRateLimited, "BitlyShortener: status %d: %s %s", status, response.Message, response.Description)
+	}
+
+	if status/100 != 2 {
+		return "", statusError(status, "BitlyShortener: status %d: %s %s", status, response.Message, response.Description)
+	}
+
+	if response.Link == "" {
+		return "", errors.New("BitlyShortener: the response contains no link")
+	}
+
+	return response.Link, nil
+
+}
+
+// HTTPShortener is a generic Shortener for self-hosted
+// services, like YOURLS or Kutt, that accept the long URL
+// in a parameter and reply with the short one in a JSON field.
+type HTTPShortener struct {
+	// Endpoint is the URL of the API.
+	Endpoint string
+	// Method is the HTTP method, http.MethodGet or http.MethodPost.
+	Method string
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+	// Parameters are added to every request, e.g. for authentication.
+	Parameters map[string]string
+	// URLParameter is the name of the parameter holding the long URL.
+	URLParameter string
+	// JSONBody tells whether the parameters of POST requests are sent
+	// as a JSON object instead of a form. GET requests always use the query.
+	JSONBody bool
+	// ResponseField is the field of the JSON response holding the short URL.
+	ResponseField string
+	// Client is the HTTP client used for the requests.
+	Client *http.Client
+}
+
+// NewYOURLSShortener returns an HTTPShortener for
+// the YOURLS instance at the provided base URL.
+func NewYOURLSShortener(baseURL string, signature string) *HTTPShortener {
+
+	return &HTTPShortener{
+		Endpoint: strings.TrimSuffix(baseURL, "/") + "/yourls-api.php",
+		Method:   http.MethodPost,
+		Parameters: map[string]string{
+			"signature": signature,
+			"action":    "shorturl",
+			"format":    "json",
+		},
+		URLParameter:  "url",
+		ResponseField: "shorturl",
+		Client:        &http.Client{Timeout: shortenerTimeout},
+	}
+
+}
+
+// NewKuttShortener returns an HTTPShortener for
+// the Kutt instance at the provided base URL.
+func NewKuttShortener(baseURL string, apiKey string) *HTTPShortener {
+
+	return &HTTPShortener{
+		Endpoint:      strings.TrimSuffix(baseURL, "/") + "/api/v2/links",
+		Method:        http.MethodPost,
+		Headers:       map[string]string{"X-API-KEY": apiKey},
+		URLParameter:  "target",
+		JSONBody:      true,
+		ResponseField: "link",
+		Client:        &http.Client{Timeout: shortenerTimeout},
+	}
+
+}
+
+// Shorten shortens a URL using the configured service.
+func (h *HTTPShortener) Shorten(ctx context.Context, longURL string) (string, error) {
+
+	request, err := h.newRequest(longURL)
+	if err != nil {
+		return "", errors.Errorf("HTTPShortener: unable to create request: %s", err)
+	}
+
+	var response map[string]interface{}
+	status, err := doJSON(h.Client, request.WithContext(ctx), &response)
+	if err != nil {
+		return "", errors.Wrapf(ErrShortenerUnavailable, "HTTPShortener: %s", err)
+	}
+
+	if status/100 != 2 {
+		return "", statusError(status, "HTTPShortener: status %d: %v", status, response)
+	}
+
+	shortURL, ok := response[h.ResponseField].(string)
+	if !ok || shortURL == "" {
+		return "", errors.Errorf("HTTPShortener: the response contains no %s field", h.ResponseField)
+	}
+
+	return shortURL, nil
+
+}
+
+// newRequest creates the request to shorten the URL.
+func (h *HTTPShortener) newRequest(longURL string) (*http.Request, error) {
+
+	parameters := make(map[string]string, len(h.Parameters)+1)
+	for key, value := range h.Parameters {
+		parameters[key] = value
+	}
+	parameters[h.URLParameter] = longURL
+
+	values := url.Values{}
+	for key, value := range parameters {
+		values.Set(key, value)
+	}
+
+	var request *http.Request
+	var err error
+
+	switch {
+	case h.Method == http.MethodGet:
+		request, err = http.NewRequest(http.MethodGet, h.Endpoint+"?"+values.Encode(), nil)
+	case h.JSONBody:
+		var payload []byte
+		payload, err = json.Marshal(parameters)
+		if err != nil {
+			return nil, err
+		}
+		request, err = http.NewRequest(h.Method, h.Endpoint, bytes.NewReader(payload))
+		if err == nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+	default:
+		request, err = http.NewRequest(h.Method, h.Endpoint, strings.NewReader(values.Encode()))
+		if err == nil {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range h.Headers {
+		request.Header.Set(key, value)
+	}
+
+	return request, nil
+
+}
+
+// statusError returns the error of a shortener response with
+// an unsuccessful status code, caused by ErrRateLimited or
+// ErrShortenerUnavailable if the failure is temporary.
+func statusError(status int, format string, args ...interface{}) error {
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		return errors.Wrapf(ErrRateLimited, format, args...)
+	case status/100 == 5:
+		return errors.Wrapf(ErrShortenerUnavailable, format, args...)
+	default:
+		return errors.Errorf(format, args...)
+	}
+
+}
+
+// isTemporary returns true if the shortening failed
+// because of a rate limit or an unavailable service.
+func isTemporary(err error) bool {
+	cause := errors.Cause(err)
+	return cause == ErrRateLimited || cause == ErrShortenerUnavailable
+}
+
+// doJSON performs the request and decodes the JSON response body,
+// whatever the status code, returning the status code.
+func doJSON(client *http.Client, request *http.Request, response interface{}) (int, error) {
+
+	if client == nil {
+		client = &http.Client{Timeout: shortenerTimeout}
+	}
+
+	result, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer result.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(result.Body, maxShortenerResponseSize))
+	if err != nil {
+		return result.StatusCode, errors.Errorf("unable to read the response: %s", err)
+	}
+
+	err = json.Unmarshal(body, response)
+	if err != nil && result.StatusCode/100 == 2 {
+		return result.StatusCode, errors.Errorf("unable to decode the response: %s", err)
+	}
+
+	return result.StatusCode, nil
+
+}
@@ -0,0 +1,379 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package urlwork
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const longTestURL = "https://www.amazon.it/dp/B0794VJ18B?tag=mytag-21"
+
+func TestNoopShortener(t *testing.T) {
+
+	got, err := NoopShortener{}.Shorten(context.Background(), longTestURL)
+	if err != nil || got != longTestURL {
+		t.Errorf("NoopShortener.Shorten() = %v, %v, want %v, nil", got, err, longTestURL)
+	}
+
+}
+
+func TestBitlyShortener(t *testing.T) {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.URL.Path != "/v4/shorten" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"FORBIDDEN","description":"Invalid token"}`))
+			return
+		}
+
+		var request bitlyShortenRequest
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		switch {
+		case request.LongURL != longTestURL:
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message":"INVALID_ARG_LONG_URL"}`))
+		case request.GroupGUID == "empty":
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"link":"https://` + request.Domain + `/abc"}`))
+		}
+
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name        string
+		accessToken string
+		domain      string
+		groupGUID   string
+		want        string
+		wantErr     bool
+	}{
+		{
+			name:        "Default domain",
+			accessToken: "token",
+			want:        "https://bit.ly/abc",
+		},
+		{
+			name:        "Custom domain",
+			accessToken: "token",
+			domain:      "amzn.example",
+			groupGUID:   "Ba1bc23dE4F",
+			want:        "https://amzn.example/abc",
+		},
+		{
+			name:        "Wrong token",
+			accessToken: "wrong",
+			wantErr:     true,
+		},
+		{
+			name:        "Response without link",
+			accessToken: "token",
+			groupGUID:   "empty",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			shortener := NewBitlyShortener(tt.accessToken, tt.domain, tt.groupGUID)
+			shortener.BaseURL = server.URL + "/v4/"
+			shortener.Client = server.Client()
+
+			got, err := shortener.Shorten(context.Background(), longTestURL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("BitlyShortener.Shorten() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("BitlyShortener.Shorten() = %v, want %v", got, tt.want)
+			}
+
+		})
+	}
+
+}
+
+func TestHTTPShortener(t *testing.T) {
+
+	mux := http.NewServeMux()
+
+	// YOURLS accepts the parameters as a form.
+	mux.HandleFunc("/yourls-api.php", func(w http.ResponseWriter, r *http.Request) {
+
+		if r.PostFormValue("signature") != "signature" || r.PostFormValue("action") != "shorturl" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errorCode":403,"message":"Please log in"}`))
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"status":"success","shorturl":"https://sho.rt/` + r.PostFormValue("format") + `","url":{"url":"` + r.PostFormValue("url") + `"}}`))
+
+	})
+
+	// Kutt accepts the parameters as JSON.
+	mux.HandleFunc("/api/v2/links", func(w http.ResponseWriter, r *http.Request) {
+
+		var request map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&request)
+
+		if r.Header.Get("X-API-KEY") != "key" || request["target"] != longTestURL {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"Unauthorized"}`))
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"link":"https://kutt.it/xyz","target":"` + request["target"] + `"}`))
+
+	})
+
+	// A generic service replying to GET requests.
+	mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"short":"https://sho.rt/` + r.URL.Query().Get("key") + `"}`))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		shortener *HTTPShortener
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "YOURLS",
+			shortener: NewYOURLSShortener(server.URL+"/", "signature"),
+			want:      "https://sho.rt/json",
+		},
+		{
+			name:      "YOURLS wrong signature",
+			shortener: NewYOURLSShortener(server.URL, "wrong"),
+			wantErr:   true,
+		},
+		{
+			name:      "Kutt",
+			shortener: NewKuttShortener(server.URL, "key"),
+			want:      "https://kutt.it/xyz",
+		},
+		{
+			name:      "Kutt wrong key",
+			shortener: NewKuttShortener(server.URL, "wrong"),
+			wantErr:   true,
+		},
+		{
+			name: "GET request",
+			shortener: &HTTPShortener{
+				Endpoint:      server.URL + "/get",
+				Method:        http.MethodGet,
+				Parameters:    map[string]string{"key": "k"},
+				URLParameter:  "url",
+				ResponseField: "short",
+			},
+			want: "https://sho.rt/k",
+		},
+		{
+			name: "Missing response field",
+			shortener: &HTTPShortener{
+				Endpoint:      server.URL + "/get",
+				Method:        http.MethodGet,
+				URLParameter:  "url",
+				ResponseField: "link",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got, err := tt.shortener.Shorten(context.Background(), longTestURL)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HTTPShortener.Shorten() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("HTTPShortener.Shorten() = %v, want %v", got, tt.want)
+			}
+
+		})
+	}
+
+}
+
+func TestBitlyShortener_errors(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		wantCause error
+	}{
+		{"rate limit", http.StatusTooManyRequests, `{"message":"RATE_LIMIT_EXCEEDED"}`, ErrRateLimited},
+		{"monthly quota", http.StatusForbidden, `{"message":"MONTHLY_RATE_LIMIT_EXCEEDED"}`, ErrRateLimited},
+		{"unavailable", http.StatusServiceUnavailable, `{"message":"TEMPORARILY_UNAVAILABLE"}`, ErrShortenerUnavailable},
+		{"invalid token", http.StatusForbidden, `{"message":"FORBIDDEN"}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			shortener := NewBitlyShortener("token", "", "")
+			shortener.BaseURL = server.URL
+			shortener.Client = server.Client()
+
+			_, err := shortener.Shorten(context.Background(), longTestURL)
+			if err == nil {
+				t.Fatal("BitlyShortener.Shorten() error = nil, want an error")
+			}
+
+			cause := errors.Cause(err)
+			if tt.wantCause != nil && cause != tt.wantCause || tt.wantCause == nil && isTemporary(err) {
+				t.Errorf("BitlyShortener.Shorten() error = %v, want cause %v", err, tt.wantCause)
+			}
+
+		})
+	}
+
+}
+
+// flakyShortener is a Shortener that fails with the
+// given errors before shortening the URL.
+type flakyShortener struct {
+	errs  []error
+	calls int
+}
+
+func (f *flakyShortener) Shorten(_ context.Context, longURL string) (string, error) {
+
+	f.calls++
+	if f.calls <= len(f.errs) {
+		return "", f.errs[f.calls-1]
+	}
+
+	return "https://bit.ly/abc", nil
+
+}
+
+func TestRetryShortener(t *testing.T) {
+
+	unavailable := errors.Wrap(ErrShortenerUnavailable, "status 503")
+	rateLimited := errors.Wrap(ErrRateLimited, "status 429")
+
+	tests := []struct {
+		name      string
+		errs      []error
+		want      string
+		wantErr   bool
+		wantCalls int
+	}{
+		{"success", nil, "https://bit.ly/abc", false, 1},
+		{"unavailable then success", []error{unavailable, unavailable}, "https://bit.ly/abc", false, 3},
+		{"always unavailable", []error{unavailable, unavailable, unavailable}, "", true, 3},
+		{"rate limited", []error{rateLimited}, "", true, 1},
+		{"permanent failure", []error{errors.New("FORBIDDEN")}, "", true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			flaky := &flakyShortener{errs: tt.errs}
+			got, err := NewRetryShortener(flaky, 2, time.Millisecond).Shorten(context.Background(), longTestURL)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("RetryShortener.Shorten() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+
+			if flaky.calls != tt.wantCalls {
+				t.Errorf("RetryShortener.Shorten() made %d attempts, want %d", flaky.calls, tt.wantCalls)
+			}
+
+		})
+	}
+
+}
+
+func TestGetReferral_shortenerFallback(t *testing.T) {
+
+	referralIDs := map[string]string{"amazon.it": "italian-21"}
+	link := "https://www.amazon.it/dp/B00TEST123"
+	longURL := CanonicalURL("amazon.it", "B00TEST123", "italian-21", nil)
+
+	tests := []struct {
+		name     string
+		err      error
+		want     string
+		wantKind ErrorKind
+	}{
+		{"rate limited", errors.Wrap(ErrRateLimited, "status 429"), longURL, KindUnknown},
+		{"unavailable", errors.Wrap(ErrShortenerUnavailable, "status 503"), longURL, KindUnknown},
+		{"permanent failure", errors.New("FORBIDDEN"), "", KindShortenerFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			cache := mapCache{}
+			referral, err := GetReferral(context.Background(), link, referralIDs, NewHTTPResolver(nil), &flakyShortener{errs: []error{tt.err}}, cache)
+			if referral.URL != tt.want || KindOf(err) != tt.wantKind {
+				t.Errorf("GetReferral() = %+v, %v, want %s, kind %d", referral, err, tt.want, tt.wantKind)
+			}
+
+			if len(cache) != 0 {
+				t.Errorf("GetReferral() cached %v, want no links", cache)
+			}
+
+		})
+	}
+
+}
+
+func TestGetReferrals_hangingShortener(t *testing.T) {
+
+	// The server never replies, until the test is over.
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	shortener := NewRetryShortener(NewYOURLSShortener(server.URL, "signature"), DefaultShortenerRetries, DefaultShortenerBackoff)
+	referralIDs := map[string]string{"amazon.it": "italian-21"}
+	links := []string{"https://www.amazon.it/dp/B00TEST123"}
+	timeout := shortenerDeadlineMargin + 300*time.Millisecond
+
+	start := time.Now()
+	results := GetReferrals(links, referralIDs, NewHTTPResolver(nil), shortener, nil, 1, timeout)
+	if elapsed := time.Since(start); elapsed > timeout+100*time.Millisecond {
+		t.Errorf("GetReferrals() took %s, want at most %s", elapsed, timeout)
+	}
+
+	want := CanonicalURL("amazon.it", "B00TEST123", "italian-21", nil)
+	if results[0].Err != nil || results[0].Referral.URL != want {
+		t.Errorf("GetReferrals() = %+v, want the long referral link %s", results[0], want)
+	}
+
+}
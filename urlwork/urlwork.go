@@ -7,101 +7,119 @@
 package urlwork
 
 import (
-	"net/http"
+	"context"
 	"net/url"
 	"strings"
 
 	"github.com/pkg/errors"
-
-	"github.com/retgits/bitly/client"
-	"github.com/retgits/bitly/client/bitlinks"
-
-	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
 )
 
-//GetRefURL tries to generate an Amazon referral link.
-func GetRefURL(link string, referral string, b *client.Client) (string, error) {
-
-	parsedURL, err := url.Parse(link)
-	if err != nil {
-		return "", errors.Errorf("%s is not a valid URL: %s", link, err)
-	}
+// ParseLink parses a link found in a message.
+// Telegram also detects links without a scheme, like
+// amzn.to/2lVEfGs: in this case, https is assumed.
+func ParseLink(link string) (*url.URL, error) {
 
-	// Default to http scheme in case the field is missing.
-	if parsedURL.Scheme == "" {
-		parsedURL.Scheme = "http"
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
 	}
 
-	parsedURL, err = unshortenURL(parsedURL)
+	parsedURL, err := url.Parse(link)
 	if err != nil {
-		return "", errors.Errorf("Unable to unshorten URL %s: %s", link, err)
+		return nil, errors.Errorf("%s is not a valid URL: %s", link, err)
 	}
 
-	//It has to be an AmazonDomain URL
-	if !strings.HasSuffix(parsedURL.Host, repository.AmazonDomain) {
-		return "", errors.Errorf("Amazon domain not supported for URL %s", parsedURL.String())
+	if parsedURL.Hostname() == "" {
+		return nil, errors.Errorf("%s is not a valid URL: missing host", link)
 	}
 
-	// Build the referral URL.
-	parsedURL.Path = cutPathAtASIN(parsedURL.Path)
-	parsedURL.RawQuery = "&tag=" + referral
-	parsedURL.Fragment = ""
-
-	bLinks := bitlinks.New(b)
-	return shortenURL(parsedURL.String(), bLinks)
+	return parsedURL, nil
 
 }
 
-// cutPathAtASIN returns a copy of the provided path up to the ASIN.
-func cutPathAtASIN(path string) string {
+//GetRefURL tries to generate an Amazon referral link, using the
+//referral ID of the marketplace the link belongs to.
+//Short links are resolved with the provided Resolver.
+//The shortened links are reused from the cache, if not nil.
+//The network requests are cancelled when the context is done.
+func GetRefURL(ctx context.Context, link string, referralIDs map[string]string, resolver Resolver, shortener Shortener, cache LinkCache) (string, error) {
 
-	var builder strings.Builder
+	referral, err := GetReferral(ctx, link, referralIDs, resolver, shortener, cache)
+	return referral.URL, err
 
-	// We want to rebuild the path segment by segment.
-	// We will stop once we've reached the ASIN code.
-	pathSegments := strings.Split(path, "/")
-	for index, part := range pathSegments {
+}
 
-		builder.WriteString(part)
-		builder.WriteString("/")
+// Referral is a referral link to an Amazon product.
+// It contains the marketplace and the ASIN of the
+// product, the referral ID used, the options of the
+// link kept by FilterQuery, encoded as a query, and
+// the, possibly shortened, referral URL.
+type Referral struct {
+	Marketplace string
+	ASIN        string
+	Tag         string
+	Options     string
+	URL         string
+}
 
-		if index > 0 && (pathSegments[index-1] == "product" || pathSegments[index-1] == "dp" || pathSegments[index-1] == "d") {
-			break
-		}
+// GetReferral works like GetRefURL, but it also
+// returns the product the referral link points to.
+// Errors are *LinkError, telling why the link
+// couldn't be converted.
+func GetReferral(ctx context.Context, link string, referralIDs map[string]string, resolver Resolver, shortener Shortener, cache LinkCache) (Referral, error) {
 
+	parsedURL, err := ParseLink(link)
+	if err != nil {
+		return Referral{}, &LinkError{Kind: KindInvalidURL, Link: link, Err: err}
 	}
 
-	return builder.String()
-
-}
+	// Only links that can't be resolved offline,
+	// like amzn.to ones, require network requests.
+	resolvedURL, ok := ResolveOffline(parsedURL)
+	if !ok {
+		resolvedURL, err = resolver.Resolve(ctx, parsedURL)
+		if errors.Cause(err) == ErrHostNotAllowed {
+			return Referral{}, newLinkError(KindNotAmazon, link, "%s is not an Amazon link: %s", link, err)
+		}
+		if err != nil {
+			return Referral{}, newLinkError(KindResolverFailure, link, "Unable to unshorten URL %s: %s", link, err)
+		}
+	}
+	parsedURL = resolvedURL
 
-// shortenURL shortens a URL using Bitly.
-func shortenURL(u string, b *bitlinks.Bitlinks) (string, error) {
+	//It has to be the URL of a supported Amazon marketplace.
+	marketplace, tag, err := ReferralIDFor(parsedURL.Hostname(), referralIDs)
+	if err != nil {
+		kind := KindUnsupportedMarketplace
+		if marketplace == "" {
+			kind = KindNotAmazon
+		}
+		return Referral{}, newLinkError(kind, link, "Unable to generate a referral link for URL %s: %s", parsedURL.String(), err)
+	}
 
-	toShort := bitlinks.ShortenRequest{
-		GroupGUID: "",
-		LongURL:   u,
-		Domain:    "bit.ly",
+	asin, err := ExtractASIN(parsedURL)
+	if err != nil {
+		return Referral{}, newLinkError(KindNotAProduct, link, "Unable to find the product in URL %s: %s", parsedURL.String(), err)
 	}
 
-	shortURL, err := b.ShortenLink(&toShort)
+	options := FilterQuery(parsedURL.Query())
+	shortURL, err := shortenReferral(ctx, marketplace, asin, tag, options, shortener, cache)
 	if err != nil {
-		err = errors.Errorf("Error while shortening the URL: %s", err)
-		return "", err
+		return Referral{}, &LinkError{Kind: KindShortenerFailure, Link: link, Err: err}
 	}
 
-	return shortURL.Link, nil
+	return Referral{Marketplace: marketplace, ASIN: asin, Tag: tag, Options: options.Encode(), URL: shortURL}, nil
 
 }
 
-// unshortenURL performs a HEAD request to unshorten an URL.
-func unshortenURL(u *url.URL) (*url.URL, error) {
+// shortenURL shortens a URL using the provided Shortener.
+func shortenURL(ctx context.Context, u string, shortener Shortener) (string, error) {
 
-	result, err := http.Head(u.String())
+	shortURL, err := shortener.Shorten(ctx, u)
 	if err != nil {
-		return nil, err
+		err = errors.Wrap(err, "Error while shortening the URL")
+		return "", err
 	}
 
-	return result.Request.URL, nil
+	return shortURL, nil
 
 }
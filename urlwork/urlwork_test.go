@@ -5,23 +5,20 @@
 package urlwork
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
-	"github.com/AlessandroPomponio/serverless-amazon-refbot/repository"
-)
-
-func getPathFromString(rawURL string, t *testing.T) string {
-
-	parsedURL, err := url.Parse(rawURL)
-	if err != nil {
-		t.Errorf("getPathFromString: unable to parse raw url %s: %s", rawURL, err)
-	}
+	"github.com/pkg/errors"
 
-	return parsedURL.Path
-
-}
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/urlwork/urlworktest"
+)
 
 func getURLFromString(rawURL string, t *testing.T) *url.URL {
 
@@ -34,9 +31,7 @@ func getURLFromString(rawURL string, t *testing.T) *url.URL {
 
 }
 
-func Test_cutPathAtASIN(t *testing.T) {
-
-	repository.AmazonDomain = "amazon.it"
+func TestExtractASIN(t *testing.T) {
 
 	firstURL := "https://www.amazon.it/Buono-Regalo-Amazon-it-Da-stampare/dp/B005VEAJK6/a-lot-of-things-that-should-not-be-in-the-output-of-the-function"
 	secondURL := "https://www.amazon.it/dp/B078WST5RK/a-lot-of-things-that-should-not-be-in-the-output-of-the-function"
@@ -44,87 +39,527 @@ func Test_cutPathAtASIN(t *testing.T) {
 	fourthURL := "https://www.amazon.it/gp/product/B0794VJ18B/a-lot-of-things-that-should-not-be-in-the-output-of-the-function"
 
 	tests := []struct {
-		name string
-		path string
-		want string
+		name    string
+		url     *url.URL
+		want    string
+		wantErr bool
 	}{
 		{
 			name: "domain/name/dp/ASIN",
-			path: getPathFromString(firstURL, t),
-			want: "/Buono-Regalo-Amazon-it-Da-stampare/dp/B005VEAJK6/",
+			url:  getURLFromString(firstURL, t),
+			want: "B005VEAJK6",
 		},
 		{
 			name: "domain/dp/ASIN",
-			path: getPathFromString(secondURL, t),
-			want: "/dp/B078WST5RK/",
+			url:  getURLFromString(secondURL, t),
+			want: "B078WST5RK",
 		},
 		{
 			name: "domain/gp/aw/d/ASIN",
-			path: getPathFromString(thirdURL, t),
-			want: "/gp/aw/d/B0794VJ18B/",
+			url:  getURLFromString(thirdURL, t),
+			want: "B0794VJ18B",
 		},
 		{
 			name: "domain/gp/product/ASIN",
-			path: getPathFromString(fourthURL, t),
-			want: "/gp/product/B0794VJ18B/",
+			url:  getURLFromString(fourthURL, t),
+			want: "B0794VJ18B",
+		},
+		{
+			name: "domain/dp/product/ASIN",
+			url:  getURLFromString("https://www.amazon.com/dp/product/B0794VJ18B?th=1", t),
+			want: "B0794VJ18B",
+		},
+		{
+			name: "domain/gp/offer-listing/ASIN",
+			url:  getURLFromString("https://www.amazon.de/gp/offer-listing/B078WST5RK/ref=dp_olp_NEW_mbc", t),
+			want: "B078WST5RK",
+		},
+		{
+			name: "domain/exec/obidos/ASIN/ASIN",
+			url:  getURLFromString("https://www.amazon.com/exec/obidos/ASIN/B005VEAJK6/someassociate-20", t),
+			want: "B005VEAJK6",
+		},
+		{
+			name: "domain/exec/obidos/tg/detail/-/ASIN",
+			url:  getURLFromString("https://www.amazon.com/exec/obidos/tg/detail/-/B005VEAJK6", t),
+			want: "B005VEAJK6",
+		},
+		{
+			name: "domain/o/ASIN/ASIN",
+			url:  getURLFromString("https://www.amazon.co.uk/o/ASIN/B078WST5RK", t),
+			want: "B078WST5RK",
+		},
+		{
+			name: "ISBN-10 ASIN",
+			url:  getURLFromString("https://www.amazon.it/Il-nome-della-rosa/dp/884520246X/ref=sr_1_1", t),
+			want: "884520246X",
+		},
+		{
+			name: "Lowercase ASIN",
+			url:  getURLFromString("https://www.amazon.it/dp/b078wst5rk", t),
+			want: "B078WST5RK",
+		},
+		{
+			name: "Query asin parameter",
+			url:  getURLFromString("https://www.amazon.fr/gp/aws/cart/add.html?ASIN=B0794VJ18B&Quantity.1=1", t),
+			want: "B0794VJ18B",
+		},
+		{
+			name:    "Not an ASIN after dp",
+			url:     getURLFromString("https://www.amazon.it/dp/not-an-asin", t),
+			wantErr: true,
+		},
+		{
+			name:    "Search page",
+			url:     getURLFromString("https://www.amazon.es/s?k=kindle", t),
+			wantErr: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := cutPathAtASIN(tt.path); got != tt.want {
-				t.Errorf("cutPathAtASIN() = %v, want %v", got, tt.want)
+			got, err := ExtractASIN(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExtractASIN() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ExtractASIN() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
-func Test_unshortenURL(t *testing.T) {
+func TestCanonicalURL(t *testing.T) {
 
 	tests := []struct {
-		name    string
-		args    *url.URL
-		want    *url.URL
-		wantErr bool
+		name        string
+		marketplace string
+		asin        string
+		referralID  string
+		options     url.Values
+		want        string
 	}{
 		{
-			name:    "Amazon short URL https",
-			args:    getURLFromString("https://amzn.to/2lVEfGs", t),
-			want:    getURLFromString("https://www.amazon.it/gp/product/B0794VJ18B/a-lot-of-things-that-should-not-be-in-the-output-of-the-function", t),
-			wantErr: false,
+			name:        "amazon.it",
+			marketplace: "amazon.it",
+			asin:        "B0794VJ18B",
+			referralID:  "mytag-21",
+			want:        "https://www.amazon.it/dp/B0794VJ18B?tag=mytag-21",
+		},
+		{
+			name:        "amazon.co.uk",
+			marketplace: "amazon.co.uk",
+			asin:        "884520246X",
+			referralID:  "mytag-21",
+			want:        "https://www.amazon.co.uk/dp/884520246X?tag=mytag-21",
+		},
+		{
+			name:        "Referral ID to escape",
+			marketplace: "amazon.com",
+			asin:        "B005VEAJK6",
+			referralID:  "my tag&x=1",
+			want:        "https://www.amazon.com/dp/B005VEAJK6?tag=my+tag%26x%3D1",
+		},
+		{
+			name:        "Variant and seller",
+			marketplace: "amazon.it",
+			asin:        "B0794VJ18B",
+			referralID:  "mytag-21",
+			options:     url.Values{"th": {"1"}, "PSC": {"1"}, "smid": {"A11 IL2"}},
+			want:        "https://www.amazon.it/dp/B0794VJ18B?psc=1&smid=A11+IL2&tag=mytag-21&th=1",
 		},
 		{
-			// We want an error here but unshortenURL will be called
+			name:        "Competing referral ID",
+			marketplace: "amazon.it",
+			asin:        "B0794VJ18B",
+			referralID:  "mytag-21",
+			options:     url.Values{"tag": {"someone-21"}, "ascsubtag": {"x"}},
+			want:        "https://www.amazon.it/dp/B0794VJ18B?tag=mytag-21",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalURL(tt.marketplace, tt.asin, tt.referralID, tt.options); got != tt.want {
+				t.Errorf("CanonicalURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPResolver(t *testing.T) {
+
+	const amazonURL = "https://www.amazon.it/gp/product/B0794VJ18B/a-lot-of-things-that-should-not-be-in-the-output-of-the-function"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/2lVEfGs", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, amazonURL, http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/hop1", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/hop2", http.StatusFound)
+	})
+	mux.HandleFunc("/hop2", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, amazonURL, http.StatusFound)
+	})
+	mux.HandleFunc("/get-only", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.Redirect(w, r, amazonURL, http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/1g3AhR6", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://bitly.com/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/internal", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// httptest servers listen on the loopback interface,
+	// so the tests must allow it explicitly.
+	allowAll := func(net.IP) bool { return true }
+	resolver := newHTTPResolver([]string{"127.0.0.1"}, allowAll)
+
+	tests := []struct {
+		name     string
+		resolver *HTTPResolver
+		args     *url.URL
+		want     *url.URL
+		wantErr  bool
+	}{
+		{
+			name:     "Amazon short URL",
+			resolver: resolver,
+			args:     getURLFromString(server.URL+"/2lVEfGs", t),
+			want:     getURLFromString(amazonURL, t),
+		},
+		{
+			// We want an error here but the resolver will be called
 			// by GetRefURL, which will perform the appropriate checks.
-			name:    "Amazon short URL no scheme",
-			args:    getURLFromString("amzn.to/2lVEfGs", t),
-			want:    nil,
-			wantErr: true,
+			name:     "Amazon short URL no scheme",
+			resolver: resolver,
+			args:     getURLFromString("amzn.to/2lVEfGs", t),
+			wantErr:  true,
+		},
+		{
+			name:     "Amazon URL",
+			resolver: resolver,
+			args:     getURLFromString(amazonURL, t),
+			want:     getURLFromString(amazonURL, t),
+		},
+		{
+			name:     "Relative redirects",
+			resolver: resolver,
+			args:     getURLFromString(server.URL+"/hop1", t),
+			want:     getURLFromString(amazonURL, t),
+		},
+		{
+			name:     "HEAD not allowed",
+			resolver: resolver,
+			args:     getURLFromString(server.URL+"/get-only", t),
+			want:     getURLFromString(amazonURL, t),
+		},
+		{
+			name:     "Page without redirect",
+			resolver: resolver,
+			args:     getURLFromString(server.URL+"/page", t),
+			want:     getURLFromString(server.URL+"/page", t),
+		},
+		{
+			name:     "Redirect loop",
+			resolver: resolver,
+			args:     getURLFromString(server.URL+"/loop", t),
+			wantErr:  true,
+		},
+		{
+			name:     "Bitly self link",
+			resolver: resolver,
+			args:     getURLFromString(server.URL+"/1g3AhR6", t),
+			wantErr:  true,
 		},
 		{
-			name:    "Amazon short URL http",
-			args:    getURLFromString("http://amzn.to/2lVEfGs", t),
-			want:    getURLFromString("https://www.amazon.it/gp/product/B0794VJ18B/a-lot-of-things-that-should-not-be-in-the-output-of-the-function", t),
-			wantErr: false,
+			name:     "Redirect to internal address",
+			resolver: resolver,
+			args:     getURLFromString(server.URL+"/internal", t),
+			wantErr:  true,
 		},
 		{
-			name:    "Bitly self link",
-			args:    getURLFromString("https://bitly.is/1g3AhR6", t),
-			want:    getURLFromString("https://bitly.com/", t),
-			wantErr: false,
+			name:     "Host not allowed",
+			resolver: resolver,
+			args:     getURLFromString("http://localhost/2lVEfGs", t),
+			wantErr:  true,
+		},
+		{
+			name:     "Loopback address refused",
+			resolver: NewHTTPResolver([]string{"127.0.0.1"}),
+			args:     getURLFromString(server.URL+"/2lVEfGs", t),
+			wantErr:  true,
+		},
+		{
+			name: "Timeout",
+			resolver: func() *HTTPResolver {
+				slow := newHTTPResolver([]string{"127.0.0.1"}, allowAll)
+				slow.Client.Timeout = 50 * time.Millisecond
+				return slow
+			}(),
+			args:    getURLFromString(server.URL+"/slow", t),
+			wantErr: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := unshortenURL(tt.args)
+			got, err := tt.resolver.Resolve(context.Background(), tt.args)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("unshortenURL() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("unshortenURL() got = %v, want %v", got, tt.want)
+				t.Errorf("Resolve() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isPublicIP(t *testing.T) {
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "52.94.236.248", want: true},
+		{ip: "2a01:4f8::1", want: true},
+		{ip: "127.0.0.1", want: false},
+		{ip: "10.1.2.3", want: false},
+		{ip: "172.31.255.255", want: false},
+		{ip: "192.168.1.1", want: false},
+		{ip: "169.254.169.254", want: false},
+		{ip: "100.64.0.1", want: false},
+		{ip: "0.0.0.0", want: false},
+		{ip: "::1", want: false},
+		{ip: "fd00::1", want: false},
+		{ip: "::ffff:127.0.0.1", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("isPublicIP() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestGetRefURL(t *testing.T) {
+
+	referralIDs := map[string]string{"amazon.it": "italian-21", "amazon.com": "american-20"}
+
+	tests := []struct {
+		name      string
+		link      string
+		want      string
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "Amazon product URL",
+			link:      "https://www.amazon.it/Buono-Regalo-Amazon-it-Da-stampare/dp/B005VEAJK6/ref=sr_1_1?keywords=buono",
+			want:      "https://www.amazon.it/dp/B005VEAJK6?tag=italian-21",
+			wantCalls: 0,
+		},
+		{
+			name:      "Amazon product URL with variant and tracking parameters",
+			link:      "https://www.amazon.it/dp/B005VEAJK6/ref=sr_1_1?pf_rd_p=abc&pd_rd_r=def&qid=1&sr=8-1&th=1&psc=1&tag=someone-21&linkCode=ll1",
+			want:      "https://www.amazon.it/dp/B005VEAJK6?psc=1&tag=italian-21&th=1",
+			wantCalls: 0,
+		},
+		{
+			name:      "Amazon product URL without scheme",
+			link:      "amazon.it/gp/product/B0794VJ18B",
+			want:      "https://www.amazon.it/dp/B0794VJ18B?tag=italian-21",
+			wantCalls: 0,
+		},
+		{
+			name:      "Old amzn.com short link",
+			link:      "http://amzn.com/B005VEAJK6",
+			want:      "https://www.amazon.com/dp/B005VEAJK6?tag=american-20",
+			wantCalls: 0,
+		},
+		{
+			name:      "Amazon short link",
+			link:      "https://amzn.to/2lVEfGs",
+			want:      "https://www.amazon.it/dp/B0794VJ18B?tag=italian-21",
+			wantCalls: 1,
+		},
+		{
+			name:      "Amazon short link without scheme",
+			link:      "amzn.to/2lVEfGs",
+			want:      "https://www.amazon.it/dp/B0794VJ18B?tag=italian-21",
+			wantCalls: 1,
+		},
+		{
+			name:      "Unsupported marketplace",
+			link:      "https://www.amazon.de/dp/B0794VJ18B",
+			wantCalls: 0,
+			wantErr:   true,
+		},
+		{
+			name:      "Not a product page",
+			link:      "https://www.amazon.it/s?k=kindle",
+			wantCalls: 0,
+			wantErr:   true,
+		},
+		{
+			name:      "Unknown link",
+			link:      "https://example.com/",
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "Invalid link",
+			link:      "https://",
+			wantCalls: 0,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			resolver := urlworktest.NewResolver(map[string]string{
+				"https://amzn.to/2lVEfGs": "https://www.amazon.it/gp/product/B0794VJ18B/ref=as_li_tl?ie=UTF8&tag=someone-21",
+			})
+
+			got, err := GetRefURL(context.Background(), tt.link, referralIDs, resolver, NoopShortener{}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetRefURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetRefURL() = %v, want %v", got, tt.want)
+			}
+			if resolver.Calls != tt.wantCalls {
+				t.Errorf("GetRefURL() called the resolver %d times, want %d", resolver.Calls, tt.wantCalls)
+			}
+
+		})
+	}
+}
+
+// failingShortener is a Shortener that always fails.
+type failingShortener struct{}
+
+func (failingShortener) Shorten(_ context.Context, longURL string) (string, error) {
+	return "", errors.New("quota exceeded")
+}
+
+func TestGetReferral_errors(t *testing.T) {
+
+	referralIDs := map[string]string{"amazon.it": "italian-21"}
+	resolver := NewHTTPResolver(nil)
+
+	tests := []struct {
+		name      string
+		link      string
+		resolver  Resolver
+		shortener Shortener
+		wantKind  ErrorKind
+	}{
+		{"invalid URL", "https://", resolver, NoopShortener{}, KindInvalidURL},
+		{"not Amazon", "https://www.example.com/dp/B00TEST123", resolver, NoopShortener{}, KindNotAmazon},
+		{"short link to another site", "https://bit.ly/abc", urlworktest.NewResolver(map[string]string{"https://bit.ly/abc": "https://www.example.com"}), NoopShortener{}, KindNotAmazon},
+		{"unsupported marketplace", "https://www.amazon.de/dp/B00TEST123", resolver, NoopShortener{}, KindUnsupportedMarketplace},
+		{"not a product", "https://www.amazon.it/gp/help/customer/display.html", resolver, NoopShortener{}, KindNotAProduct},
+		{"resolver failure", "https://amzn.to/abc", urlworktest.NewResolver(nil), NoopShortener{}, KindResolverFailure},
+		{"shortener failure", "https://www.amazon.it/dp/B00TEST123", resolver, failingShortener{}, KindShortenerFailure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, err := GetReferral(context.Background(), tt.link, referralIDs, tt.resolver, tt.shortener, nil)
+			if got := KindOf(err); got != tt.wantKind {
+				t.Errorf("GetReferral() error = %v, kind %d, want kind %d", err, got, tt.wantKind)
+			}
+
+			// The kind must survive wrapping.
+			if got := KindOf(errors.Wrap(err, "wrapped")); got != tt.wantKind {
+				t.Errorf("KindOf() of a wrapped error = %d, want %d", got, tt.wantKind)
+			}
+
+		})
+	}
+
+}
+
+// countingShortener is a Shortener that counts
+// the links it shortens.
+type countingShortener struct {
+	calls int
+}
+
+func (s *countingShortener) Shorten(_ context.Context, longURL string) (string, error) {
+	s.calls++
+	return "https://bit.ly/" + strconv.Itoa(s.calls), nil
+}
+
+// mapCache is a LinkCache that keeps the links in a map.
+type mapCache map[string]string
+
+func (c mapCache) GetLink(marketplace string, asin string, tag string, options string) (string, bool, error) {
+	shortURL, found := c[marketplace+asin+tag+options]
+	return shortURL, found, nil
+}
+
+func (c mapCache) PutLink(marketplace string, asin string, tag string, options string, shortURL string) error {
+	c[marketplace+asin+tag+options] = shortURL
+	return nil
+}
+
+func TestGetReferral_cache(t *testing.T) {
+
+	referralIDs := map[string]string{"amazon.it": "italian-21", "amazon.de": "german-21"}
+	resolver := NewHTTPResolver(nil)
+	shortener := &countingShortener{}
+	cache := mapCache{}
+
+	tests := []struct {
+		link      string
+		want      string
+		wantCalls int
+	}{
+		{"https://www.amazon.it/dp/B00TEST123", "https://bit.ly/1", 1},
+		{"https://www.amazon.it/Some-Product/dp/B00TEST123/ref=sr_1_1", "https://bit.ly/1", 1},
+		{"https://www.amazon.de/dp/B00TEST123", "https://bit.ly/2", 2},
+		{"https://www.amazon.it/dp/B00TEST456", "https://bit.ly/3", 3},
+		{"https://www.amazon.it/dp/B00TEST123?smid=A11IL2&qid=1", "https://bit.ly/4", 4},
+		{"https://www.amazon.it/dp/B00TEST123?smid=A11IL2&qid=2", "https://bit.ly/4", 4},
+	}
+
+	for _, tt := range tests {
+
+		got, err := GetRefURL(context.Background(), tt.link, referralIDs, resolver, shortener, cache)
+		if err != nil || got != tt.want {
+			t.Errorf("GetRefURL(%s) = %s, %v, want %s, nil", tt.link, got, err, tt.want)
+		}
+
+		if shortener.calls != tt.wantCalls {
+			t.Errorf("GetRefURL(%s) shortened %d links, want %d", tt.link, shortener.calls, tt.wantCalls)
+		}
+
+	}
+
+}
@@ -0,0 +1,53 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package urlworktest provides an urlwork.Resolver
+// that resolves the short links without a network.
+package urlworktest
+
+import (
+	"context"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Resolver is an urlwork.Resolver that resolves the links from
+// a map, recording the calls. The links that are not in the map
+// can't be resolved: they fail with NotFound, if set, e.g. with
+// urlwork.ErrHostNotAllowed to act like the hosts that are not
+// short link ones.
+type Resolver struct {
+	Links    map[string]string
+	NotFound error
+	Calls    int
+
+	mutex sync.Mutex
+}
+
+// NewResolver returns a Resolver of the links.
+func NewResolver(links map[string]string) *Resolver {
+	return &Resolver{Links: links}
+}
+
+// Resolve returns the destination of the link in the map.
+func (r *Resolver) Resolve(_ context.Context, u *url.URL) (*url.URL, error) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.Calls++
+	resolved, ok := r.Links[u.String()]
+	if !ok && r.NotFound != nil {
+		return nil, errors.Wrapf(r.NotFound, "%s is not a short link", u)
+	}
+
+	if !ok {
+		return nil, errors.Errorf("%s is not a short link", u)
+	}
+
+	return url.Parse(resolved)
+
+}
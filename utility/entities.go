@@ -0,0 +1,137 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utility
+
+import (
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// FormatEntitiesHTML returns the UTF-16 encoded text of a message
+// as HTML, with its formatting entities converted to tags, so that
+// it can be sent again with the HTML parse mode.
+// Entities that are not formatting, e.g. URLs and hashtags,
+// are left as text for Telegram to detect them again.
+func FormatEntitiesHTML(tUTF16 []uint16, entities []tgbotapi.MessageEntity) string {
+
+	// Outer entities come first, so that they're opened first.
+	sorted := make([]tgbotapi.MessageEntity, len(entities))
+	copy(sorted, entities)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Offset != sorted[j].Offset {
+			return sorted[i].Offset < sorted[j].Offset
+		}
+		return sorted[i].Length > sorted[j].Length
+	})
+
+	builder := strings.Builder{}
+	var open []tgbotapi.MessageEntity
+	last := 0
+
+	writeText := func(end int) {
+		builder.WriteString(html.EscapeString(string(utf16.Decode(tUTF16[last:end]))))
+		last = end
+	}
+
+	closeUntil := func(position int) {
+		for len(open) > 0 && entityEnd(open[len(open)-1]) <= position {
+			top := open[len(open)-1]
+			writeText(entityEnd(top))
+			builder.WriteString(closingTag(top))
+			open = open[:len(open)-1]
+		}
+	}
+
+	for _, entity := range sorted {
+
+		if entity.Offset < last || entity.Length <= 0 || entityEnd(entity) > len(tUTF16) {
+			continue
+		}
+
+		tag := openingTag(entity)
+		if tag == "" {
+			continue
+		}
+
+		closeUntil(entity.Offset)
+
+		// Tags must be nested: an entity can't end after its parent.
+		if len(open) > 0 && entityEnd(entity) > entityEnd(open[len(open)-1]) {
+			entity.Length = entityEnd(open[len(open)-1]) - entity.Offset
+		}
+
+		writeText(entity.Offset)
+		builder.WriteString(tag)
+		open = append(open, entity)
+
+	}
+
+	closeUntil(len(tUTF16))
+	writeText(len(tUTF16))
+
+	return builder.String()
+
+}
+
+// entityEnd returns the UTF-16 offset right after the entity.
+func entityEnd(entity tgbotapi.MessageEntity) int {
+	return entity.Offset + entity.Length
+}
+
+// openingTag returns the HTML tag opening a formatting
+// entity, or an empty string for the other entities.
+func openingTag(entity tgbotapi.MessageEntity) string {
+
+	switch entity.Type {
+	case "bold":
+		return "<b>"
+	case "italic":
+		return "<i>"
+	case "underline":
+		return "<u>"
+	case "strikethrough":
+		return "<s>"
+	case "code":
+		return "<code>"
+	case "pre":
+		return "<pre>"
+	case "text_link":
+		return `<a href="` + html.EscapeString(entity.URL) + `">`
+	case "text_mention":
+		if entity.User != nil {
+			return `<a href="tg://user?id=` + strconv.Itoa(entity.User.ID) + `">`
+		}
+	}
+
+	return ""
+
+}
+
+// closingTag returns the HTML tag closing a formatting entity.
+func closingTag(entity tgbotapi.MessageEntity) string {
+
+	switch entity.Type {
+	case "bold":
+		return "</b>"
+	case "italic":
+		return "</i>"
+	case "underline":
+		return "</u>"
+	case "strikethrough":
+		return "</s>"
+	case "code":
+		return "</code>"
+	case "pre":
+		return "</pre>"
+	}
+
+	return "</a>"
+
+}
@@ -0,0 +1,63 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utility
+
+import (
+	"testing"
+	"unicode/utf16"
+)
+
+func TestFormatEntitiesHTML(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		rawJSON string
+		want    string
+	}{
+		{
+			name:    "No entities",
+			rawJSON: `{"text":"Deal: 5 < 10 & cheap"}`,
+			want:    "Deal: 5 &lt; 10 &amp; cheap",
+		},
+		{
+			name:    "Bold after emoji",
+			rawJSON: `{"text":"😀 Great deal","entities":[{"offset":3,"length":5,"type":"bold"}]}`,
+			want:    "😀 <b>Great</b> deal",
+		},
+		{
+			name:    "Nested entities",
+			rawJSON: `{"text":"Only today: link","entities":[{"offset":0,"length":16,"type":"italic"},{"offset":12,"length":4,"type":"text_link","url":"https://amzn.to/a?b=1&c=2"}]}`,
+			want:    `<i>Only today: <a href="https://amzn.to/a?b=1&amp;c=2">link</a></i>`,
+		},
+		{
+			name:    "Overlapping entities",
+			rawJSON: `{"text":"abcdef","entities":[{"offset":0,"length":4,"type":"bold"},{"offset":2,"length":4,"type":"underline"}]}`,
+			want:    "<b>ab<u>cd</u></b>ef",
+		},
+		{
+			name:    "Plain entities",
+			rawJSON: `{"text":"See amzn.to/abc #deal","entities":[{"offset":4,"length":11,"type":"url"},{"offset":16,"length":5,"type":"hashtag"}]}`,
+			want:    "See amzn.to/abc #deal",
+		},
+		{
+			name:    "Text mention",
+			rawJSON: `{"text":"Thanks Mario","entities":[{"offset":7,"length":5,"type":"text_mention","user":{"id":42}}]}`,
+			want:    `Thanks <a href="tg://user?id=42">Mario</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			msg := unmarshalTestMessage(tt.rawJSON, t)
+			got := FormatEntitiesHTML(utf16.Encode([]rune(msg.Text)), msg.Entities)
+			if got != tt.want {
+				t.Errorf("FormatEntitiesHTML() = %q, want %q", got, tt.want)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,168 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utility
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+const (
+	// ExportCSV is the format of the requests exported as
+	// comma separated values, with a header of the columns.
+	ExportCSV = "csv"
+	// ExportJSONL is the format of the requests
+	// exported as JSON objects, one per line.
+	ExportJSONL = "jsonl"
+)
+
+// RequestEncoder writes the exported requests.
+type RequestEncoder interface {
+	// Encode writes a request.
+	Encode(request structs.Request) error
+	// Flush writes any buffered data.
+	Flush() error
+}
+
+// NewRequestEncoder returns an encoder that writes
+// the requests to the writer in the given format.
+func NewRequestEncoder(format string, w io.Writer) (RequestEncoder, error) {
+
+	switch format {
+	case ExportCSV:
+		return &csvRequestEncoder{writer: csv.NewWriter(w)}, nil
+	case ExportJSONL:
+		return &jsonlRequestEncoder{encoder: json.NewEncoder(w)}, nil
+	}
+
+	return nil, errors.Errorf("NewRequestEncoder: unknown format %s", format)
+
+}
+
+// RequestColumns returns the names of the columns of the
+// exported requests, that are the fields of structs.Request,
+// so that the exports follow the structure of the table.
+func RequestColumns() []string {
+
+	requestType := reflect.TypeOf(structs.Request{})
+	columns := make([]string, requestType.NumField())
+	for i := range columns {
+		columns[i] = requestType.Field(i).Name
+	}
+
+	return columns
+
+}
+
+// requestRecord returns the values of the columns of the request.
+// The times are written in the RFC 3339 format, in UTC.
+func requestRecord(request structs.Request) []string {
+
+	value := reflect.ValueOf(request)
+	record := make([]string, value.NumField())
+	for i := range record {
+
+		field := value.Field(i)
+		switch fieldValue := field.Interface().(type) {
+		case time.Time:
+			record[i] = fieldValue.UTC().Format(time.RFC3339)
+		case string:
+			record[i] = fieldValue
+		default:
+			switch field.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				record[i] = strconv.FormatInt(field.Int(), 10)
+			default:
+				record[i] = ""
+			}
+		}
+
+	}
+
+	return record
+
+}
+
+// csvRequestEncoder writes the requests as CSV,
+// preceded by the header with the columns.
+type csvRequestEncoder struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+// Encode writes the request as a CSV record.
+func (e *csvRequestEncoder) Encode(request structs.Request) error {
+
+	if !e.headerWritten {
+		err := e.writer.Write(RequestColumns())
+		if err != nil {
+			return errors.Errorf("csvRequestEncoder.Encode: unable to write the header: %s", err)
+		}
+		e.headerWritten = true
+	}
+
+	err := e.writer.Write(requestRecord(request))
+	if err != nil {
+		return errors.Errorf("csvRequestEncoder.Encode: unable to write request %s: %s", request.XID, err)
+	}
+
+	return nil
+
+}
+
+// Flush writes the header, if there were no requests,
+// and the records buffered by the CSV writer.
+func (e *csvRequestEncoder) Flush() error {
+
+	if !e.headerWritten {
+		err := e.writer.Write(RequestColumns())
+		if err != nil {
+			return errors.Errorf("csvRequestEncoder.Flush: unable to write the header: %s", err)
+		}
+		e.headerWritten = true
+	}
+
+	e.writer.Flush()
+	err := e.writer.Error()
+	if err != nil {
+		return errors.Errorf("csvRequestEncoder.Flush: %s", err)
+	}
+
+	return nil
+
+}
+
+// jsonlRequestEncoder writes the requests as JSON Lines,
+// whose keys are the columns of the requests.
+type jsonlRequestEncoder struct {
+	encoder *json.Encoder
+}
+
+// Encode writes the request as a JSON object on its own line,
+// with the time in UTC like the CSV records.
+func (e *jsonlRequestEncoder) Encode(request structs.Request) error {
+
+	request.Time = request.Time.UTC()
+	err := e.encoder.Encode(request)
+	if err != nil {
+		return errors.Errorf("jsonlRequestEncoder.Encode: unable to write request %s: %s", request.XID, err)
+	}
+
+	return nil
+
+}
+
+// Flush does nothing, as every request is written right away.
+func (e *jsonlRequestEncoder) Flush() error {
+	return nil
+}
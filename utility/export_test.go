@@ -0,0 +1,98 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utility
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// exportedRequests are the requests in the golden files.
+var exportedRequests = []structs.Request{
+	{
+		XID:         "bq1fnbqh5ugg00c4k2ng",
+		TelegramID:  12345,
+		URL:         "https://amzn.to/2abcdef",
+		Marketplace: "amazon.it",
+		ASIN:        "B0794VJ18B",
+		Time:        time.Date(2026, 9, 1, 10, 30, 0, 0, time.UTC),
+		UnixTime:    1788258600,
+	},
+	{
+		XID:         "bq1fnbqh5ugg00c4k2o0",
+		TelegramID:  67890,
+		URL:         "https://www.amazon.de/dp/B00TEST123?psc=1,\"quoted\"",
+		Marketplace: "amazon.de",
+		ASIN:        "B00TEST123",
+		Time:        time.Date(2026, 9, 2, 13, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		UnixTime:    1788346800,
+	},
+}
+
+func TestNewRequestEncoder(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		format   string
+		requests []structs.Request
+		golden   string
+	}{
+		{"CSV", ExportCSV, exportedRequests, "requests.csv"},
+		{"JSONL", ExportJSONL, exportedRequests, "requests.jsonl"},
+		{"empty CSV", ExportCSV, nil, "empty.csv"},
+		{"empty JSONL", ExportJSONL, nil, "empty.jsonl"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var buffer bytes.Buffer
+			encoder, err := NewRequestEncoder(tt.format, &buffer)
+			if err != nil {
+				t.Fatalf("NewRequestEncoder() error = %v", err)
+			}
+
+			for _, request := range tt.requests {
+				if err = encoder.Encode(request); err != nil {
+					t.Fatalf("Encode() error = %v", err)
+				}
+			}
+
+			if err = encoder.Flush(); err != nil {
+				t.Fatalf("Flush() error = %v", err)
+			}
+
+			golden := filepath.Join("testdata", tt.golden)
+			if *update {
+				if err = ioutil.WriteFile(golden, buffer.Bytes(), 0644); err != nil {
+					t.Fatalf("unable to update %s: %v", golden, err)
+				}
+			}
+
+			want, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("unable to read %s: %v", golden, err)
+			}
+
+			if !bytes.Equal(buffer.Bytes(), want) {
+				t.Errorf("exported requests:\n%s\nwant:\n%s", buffer.Bytes(), want)
+			}
+
+		})
+	}
+
+	if _, err := NewRequestEncoder("xlsx", &bytes.Buffer{}); err == nil {
+		t.Error("NewRequestEncoder(xlsx) returned no error")
+	}
+
+}
@@ -8,6 +8,7 @@ package utility
 
 import (
 	"fmt"
+	"html"
 	"strings"
 	"time"
 
@@ -27,7 +28,7 @@ func FormatRequests(requests []structs.Request) string {
 
 		builder.WriteString(
 			fmt.Sprintf("➡️ <a href=\"tg://user?id=%d\">%d</a> requested %s on %s\n\n",
-				request.TelegramID, request.TelegramID, request.URL, FormatDate(request.Time)))
+				request.TelegramID, request.TelegramID, html.EscapeString(request.URL), FormatDate(request.Time)))
 
 	}
 
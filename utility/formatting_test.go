@@ -83,6 +83,17 @@ func TestFormatRequests(t *testing.T) {
 			},
 			want: "➡️ <a href=\"tg://user?id=777000\">777000</a> requested https://amzn.to/ on Thu 1 Jan 1970 00:00:00\n\n➡️ <a href=\"tg://user?id=777000\">777000</a> requested https://amzn.to/ on Thu 1 Jan 1970 00:00:00\n\n➡️ <a href=\"tg://user?id=777000\">777000</a> requested https://amzn.to/ on Thu 1 Jan 1970 00:00:00\n\n",
 		},
+		{
+			name: "URL to escape",
+			requests: []structs.Request{
+				{
+					TelegramID: 777000,
+					URL:        "https://www.amazon.it/dp/B00TEST123?psc=1&tag=ref-21",
+					Time:       time.Unix(0, 0).In(time.UTC),
+				},
+			},
+			want: "➡️ <a href=\"tg://user?id=777000\">777000</a> requested https://www.amazon.it/dp/B00TEST123?psc=1&amp;tag=ref-21 on Thu 1 Jan 1970 00:00:00\n\n",
+		},
 		{
 			name:     "No requests",
 			requests: []structs.Request{},
@@ -0,0 +1,59 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utility
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+// FormatStats formats the statistics of the requests as HTML.
+func FormatStats(stats structs.RequestStats) string {
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("📊 <b>Statistics since %s</b>\n\n", FormatDate(stats.Since)))
+
+	if stats.Requests == 0 {
+		builder.WriteString("No requests")
+		return builder.String()
+	}
+
+	builder.WriteString(fmt.Sprintf("Requests: %d\nUsers: %d, %d new\n", stats.Requests, stats.Users, stats.NewUsers))
+	builder.WriteString(FormatCounts("🏆 Top products", stats.TopASINs, true))
+	builder.WriteString(FormatCounts("🌍 Top marketplaces", stats.TopMarketplaces, true))
+	builder.WriteString(FormatCounts("📅 Requests per day", stats.Days, false))
+
+	return builder.String()
+
+}
+
+// FormatCounts formats a list of counts as HTML, under the title.
+// Ranked lists are numbered. It returns an empty string if
+// there are no counts.
+func FormatCounts(title string, counts []structs.Count, ranked bool) string {
+
+	if len(counts) == 0 {
+		return ""
+	}
+
+	builder := strings.Builder{}
+	builder.WriteString(fmt.Sprintf("\n<b>%s</b>\n", html.EscapeString(title)))
+
+	for i, count := range counts {
+
+		if ranked {
+			builder.WriteString(fmt.Sprintf("%d. ", i+1))
+		}
+
+		builder.WriteString(fmt.Sprintf("%s: %d\n", html.EscapeString(count.Key), count.Requests))
+
+	}
+
+	return builder.String()
+
+}
@@ -0,0 +1,76 @@
+// Copyright 2019 Alessandro Pomponio. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package utility
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AlessandroPomponio/serverless-amazon-refbot/structs"
+)
+
+func TestFormatStats(t *testing.T) {
+
+	since := time.Unix(1577577600, 0).In(time.UTC)
+
+	tests := []struct {
+		name  string
+		stats structs.RequestStats
+		want  string
+	}{
+		{
+			name:  "No requests",
+			stats: structs.RequestStats{Since: since, Days: []structs.Count{{Key: "2019-12-29"}}},
+			want:  "📊 <b>Statistics since Sun 29 Dec 2019 00:00:00</b>\n\nNo requests",
+		},
+		{
+			name: "Requests",
+			stats: structs.RequestStats{
+				Since:           since,
+				Requests:        3,
+				Users:           2,
+				NewUsers:        1,
+				TopASINs:        []structs.Count{{Key: "B00TEST001", Requests: 2}, {Key: "B00TEST002", Requests: 1}},
+				TopMarketplaces: []structs.Count{{Key: "amazon.it", Requests: 3}},
+				Days:            []structs.Count{{Key: "2019-12-29", Requests: 0}, {Key: "2019-12-30", Requests: 3}},
+			},
+			want: "📊 <b>Statistics since Sun 29 Dec 2019 00:00:00</b>\n\n" +
+				"Requests: 3\nUsers: 2, 1 new\n" +
+				"\n<b>🏆 Top products</b>\n1. B00TEST001: 2\n2. B00TEST002: 1\n" +
+				"\n<b>🌍 Top marketplaces</b>\n1. amazon.it: 3\n" +
+				"\n<b>📅 Requests per day</b>\n2019-12-29: 0\n2019-12-30: 3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatStats(tt.stats); got != tt.want {
+				t.Errorf("FormatStats() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatCounts(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		counts []structs.Count
+		ranked bool
+		want   string
+	}{
+		{"No counts", nil, true, ""},
+		{"Ranked", []structs.Count{{Key: "a<b", Requests: 1}}, true, "\n<b>Title</b>\n1. a&lt;b: 1\n"},
+		{"Not ranked", []structs.Count{{Key: "a", Requests: 1}, {Key: "b", Requests: 2}}, false, "\n<b>Title</b>\na: 1\nb: 2\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatCounts("Title", tt.counts, tt.ranked); got != tt.want {
+				t.Errorf("FormatCounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}